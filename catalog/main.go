@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/lackone/grpc-study/pkg/errcode"
+	"log"
+	"os"
+)
+
+var (
+	format string
+	output string
+	check  string
+)
+
+func init() {
+	flag.StringVar(&format, "format", "json", "导出格式：json, markdown, openapi")
+	flag.StringVar(&output, "o", "", "输出文件，默认输出到标准输出")
+	flag.StringVar(&check, "check", "", "对比已提交的错误码目录(json)，错误码被删除或重新编号时失败")
+	flag.Parse()
+}
+
+func main() {
+	entries := errcode.Catalog()
+
+	if check != "" {
+		if err := checkCatalog(check, entries); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	switch format {
+	case "json":
+		data, err = errcode.CatalogJSON(entries)
+	case "markdown", "md":
+		data = errcode.CatalogMarkdown(entries)
+	case "openapi":
+		data, err = errcode.CatalogOpenAPI(entries)
+	default:
+		err = fmt.Errorf("不支持的导出格式：%s", format)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if output == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(output, data, 0644); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func checkCatalog(file string, entries []errcode.CatalogEntry) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var committed []errcode.CatalogEntry
+	if err := json.Unmarshal(data, &committed); err != nil {
+		return err
+	}
+
+	problems := errcode.CheckCatalog(committed, entries)
+	if len(problems) == 0 {
+		return nil
+	}
+	for _, p := range problems {
+		fmt.Fprintln(os.Stderr, p)
+	}
+	return fmt.Errorf("错误码目录检查失败，共 %d 个问题", len(problems))
+}
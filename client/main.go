@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_retry "github.com/grpc-ecosystem/go-grpc-middleware/retry"
+	"github.com/lackone/grpc-study/pkg/auth"
+	"github.com/lackone/grpc-study/pkg/errcode"
 	"github.com/lackone/grpc-study/pkg/middleware"
 	"github.com/lackone/grpc-study/pkg/tracer"
 	pb "github.com/lackone/grpc-study/proto"
@@ -14,24 +17,10 @@ import (
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
 	"log"
+	"os"
+	"time"
 )
 
-type Auth struct {
-}
-
-func (a *Auth) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
-	return map[string]string{
-		"app_key":    "xxxx",
-		"app_secret": "xxxx",
-		"aaa":        "aaa",
-		"bbb":        "bbb",
-	}, nil
-}
-
-func (a *Auth) RequireTransportSecurity() bool {
-	return false
-}
-
 func main() {
 	tp, err := tracer.InitTracerProvider("127.0.0.1", "6831", "grpc-client")
 	if err != nil {
@@ -44,21 +33,47 @@ func main() {
 		}
 	}()
 
+	//按方法配置超时时间，单次调用可以使用middleware.WithCallTimeout覆盖
+	timeoutOpts := []middleware.TimeoutOption{
+		middleware.WithDefaultTimeout(60 * time.Second),
+		middleware.WithMethodTimeouts(map[string]time.Duration{
+			"/proto.ArticleService/GetArticleList": 5 * time.Second,
+		}),
+	}
+
+	breakerOpts := []middleware.BreakerOption{
+		middleware.WithFailureThreshold(5),
+		middleware.WithOpenTimeout(10 * time.Second),
+		middleware.WithHalfOpenRequests(1),
+		middleware.WithStateChange(func(method string, from, to middleware.BreakerState) {
+			log.Printf("circuit breaker: method: %s, %s -> %s\n", method, from, to)
+		}),
+	}
+
 	opts := []grpc.DialOption{
 		//客户端的拦截器
 		grpc.WithUnaryInterceptor(
 			grpc_middleware.ChainUnaryClient(
 				otelgrpc.UnaryClientInterceptor(),
 
-				middleware.UnaryContextTimeout(),
+				//把错误转换成errcode.Error
+				middleware.UnaryClientError(),
 
-				//grpc重试操作
-				grpc_retry.UnaryClientInterceptor(
+				middleware.UnaryContextTimeout(timeoutOpts...),
+
+				//熔断，放在重试之前
+				middleware.UnaryClientBreaker(breakerOpts...),
+
+				//grpc重试操作，服务端限流或降载时退避重试，不早于服务端RetryInfo建议的时间
+				middleware.UnaryClientRetry(
+					grpc_retry.BackoffExponentialWithJitter(100*time.Millisecond, 0.2),
 					grpc_retry.WithMax(2),
 					grpc_retry.WithCodes(
 						codes.Unknown,
 						codes.Internal,
 						codes.DeadlineExceeded,
+						codes.Unavailable,
+						codes.ResourceExhausted,
 					),
 				),
 			),
@@ -66,11 +81,13 @@ func main() {
 		grpc.WithStreamInterceptor(
 			grpc_middleware.ChainStreamClient(
 				otelgrpc.StreamClientInterceptor(),
-				middleware.StreamContextTimeout(),
+				middleware.StreamClientError(),
+				middleware.StreamContextTimeout(timeoutOpts...),
+				middleware.StreamClientBreaker(breakerOpts...),
 			),
 		),
-		//RPC方法做自定义认证
-		grpc.WithPerRPCCredentials(&Auth{}),
+		//RPC方法做自定义认证，使用app_secret对请求签名，凭证通过环境变量传入
+		grpc.WithPerRPCCredentials(auth.NewHMACSigner(os.Getenv("APP_KEY"), os.Getenv("APP_SECRET"))),
 	}
 
 	ctx := context.Background()
@@ -90,6 +107,15 @@ func main() {
 	client := pb.NewArticleServiceClient(conn)
 	list, err := client.GetArticleList(context.Background(), &pb.GetArticleRequest{Page: 1, Size: 4})
 	if err != nil {
+		var e *errcode.Error
+		if errors.As(err, &e) {
+			switch e.Code() {
+			case errcode.InvalidParams.Code():
+				log.Fatalln("请求参数错误：", e.Msg(), e.Details())
+			case errcode.ErrorGetArticleListFail.Code():
+				log.Fatalln("获取文章列表失败：", e.Msg(), e.Details())
+			}
+		}
 		log.Fatalln(err)
 	}
 	fmt.Println(list)
@@ -0,0 +1,25 @@
+package auth
+
+import "context"
+
+// 调用方身份
+type Identity struct {
+	//app_key认证时的应用key
+	AppKey string
+	//JWT认证时的sub
+	Subject string
+	Name    string
+	Roles   []string
+	Scopes  []string
+}
+
+type identityKey struct{}
+
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+func FromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok
+}
@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"math/big"
+	"os"
+	"strings"
+)
+
+type JWTClaims struct {
+	jwt.RegisteredClaims
+	//多个scope用空格分隔
+	Scope string   `json:"scope,omitempty"`
+	Name  string   `json:"name,omitempty"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+func (c *JWTClaims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+func (c *JWTClaims) Identity() *Identity {
+	return &Identity{
+		Subject: c.Subject,
+		Name:    c.Name,
+		Roles:   c.Roles,
+		Scopes:  c.Scopes(),
+	}
+}
+
+type JWTOption func(*JWTVerifier)
+
+// 校验HS256/RS256签名的JWT
+type JWTVerifier struct {
+	secret    []byte
+	jwksFiles []string
+	keys      map[string]interface{}
+	issuer    string
+	audience  string
+	//不允许没有exp的token，默认true
+	requireExp bool
+}
+
+// HS256使用的密钥
+func WithHMACSecret(secret []byte) JWTOption {
+	return func(v *JWTVerifier) {
+		v.secret = secret
+	}
+}
+
+// 本地JWKS文件，支持RSA(RS256)和oct(HS256)类型的key
+func WithJWKSFile(files ...string) JWTOption {
+	return func(v *JWTVerifier) {
+		v.jwksFiles = append(v.jwksFiles, files...)
+	}
+}
+
+func WithIssuer(issuer string) JWTOption {
+	return func(v *JWTVerifier) {
+		v.issuer = issuer
+	}
+}
+
+func WithAudience(audience string) JWTOption {
+	return func(v *JWTVerifier) {
+		v.audience = audience
+	}
+}
+
+// 是否拒绝没有exp的token，默认拒绝，没有过期时间的token泄露后一直有效
+func WithRequireExp(require bool) JWTOption {
+	return func(v *JWTVerifier) {
+		v.requireExp = require
+	}
+}
+
+func NewJWTVerifier(opts ...JWTOption) (*JWTVerifier, error) {
+	v := &JWTVerifier{keys: map[string]interface{}{}, requireExp: true}
+	for _, fn := range opts {
+		fn(v)
+	}
+
+	for _, file := range v.jwksFiles {
+		if err := v.loadJWKS(file); err != nil {
+			return nil, fmt.Errorf("load jwks %s: %w", file, err)
+		}
+	}
+
+	if len(v.secret) == 0 && len(v.keys) == 0 {
+		return nil, errors.New("jwt verifier has no keys")
+	}
+	return v, nil
+}
+
+func (v *JWTVerifier) Verify(token string) (*JWTClaims, error) {
+	claims := &JWTClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, v.keyFunc, jwt.WithValidMethods([]string{"HS256", "RS256"}))
+	if err != nil {
+		return nil, err
+	}
+
+	if v.requireExp && claims.ExpiresAt == nil {
+		return nil, errors.New("token has no exp")
+	}
+	if v.issuer != "" && !claims.VerifyIssuer(v.issuer, true) {
+		return nil, errors.New("invalid issuer")
+	}
+	if v.audience != "" && !claims.VerifyAudience(v.audience, true) {
+		return nil, errors.New("invalid audience")
+	}
+	return claims, nil
+}
+
+func (v *JWTVerifier) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if key, ok := v.keys[kid].([]byte); ok {
+			return key, nil
+		}
+		if len(v.secret) > 0 {
+			return v.secret, nil
+		}
+	case *jwt.SigningMethodRSA:
+		if key, ok := v.keys[kid].(*rsa.PublicKey); ok {
+			return key, nil
+		}
+		//没有kid时，只有一个RSA key可以直接使用
+		if kid == "" {
+			var found *rsa.PublicKey
+			for _, k := range v.keys {
+				if key, ok := k.(*rsa.PublicKey); ok {
+					if found != nil {
+						return nil, errors.New("token has no kid")
+					}
+					found = key
+				}
+			}
+			if found != nil {
+				return found, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no key for kid %q", kid)
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	K   string `json:"k"`
+}
+
+func (v *JWTVerifier) loadJWKS(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return err
+	}
+
+	for _, k := range set.Keys {
+		switch k.Kty {
+		case "RSA":
+			key, err := rsaPublicKey(k)
+			if err != nil {
+				return fmt.Errorf("key %q: %w", k.Kid, err)
+			}
+			v.keys[k.Kid] = key
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil {
+				return fmt.Errorf("key %q: %w", k.Kid, err)
+			}
+			v.keys[k.Kid] = secret
+		}
+	}
+	return nil
+}
+
+func rsaPublicKey(k jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
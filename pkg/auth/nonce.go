@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNonceReplayed  = errors.New("nonce replayed")
+	ErrNonceCacheFull = errors.New("nonce cache full")
+)
+
+// 有容量上限的nonce缓存，用来拒绝重放的请求
+// 超过ttl的nonce会被清理，容量已满时拒绝新的nonce，不淘汰未过期的nonce，
+// 否则大量请求挤掉旧的nonce后就可以在时间误差内重放
+type NonceCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List
+}
+
+type nonceEntry struct {
+	key     string
+	expires time.Time
+}
+
+func NewNonceCache(capacity int, ttl time.Duration) *NonceCache {
+	return &NonceCache{
+		ttl:      ttl,
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// 记录nonce，已经存在时返回ErrNonceReplayed，容量已满时返回ErrNonceCacheFull
+func (c *NonceCache) Add(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.evictExpired(now)
+
+	if _, ok := c.items[key]; ok {
+		return ErrNonceReplayed
+	}
+
+	if c.capacity > 0 && c.order.Len() >= c.capacity {
+		return ErrNonceCacheFull
+	}
+
+	c.items[key] = c.order.PushBack(&nonceEntry{key: key, expires: now.Add(c.ttl)})
+	return nil
+}
+
+func (c *NonceCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *NonceCache) evictExpired(now time.Time) {
+	for e := c.order.Front(); e != nil; e = c.order.Front() {
+		if e.Value.(*nonceEntry).expires.After(now) {
+			return
+		}
+		c.remove(e)
+	}
+}
+
+func (c *NonceCache) remove(e *list.Element) {
+	c.order.Remove(e)
+	delete(c.items, e.Value.(*nonceEntry).key)
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+)
+
+// 没有身份信息的调用方使用的角色
+const AnonymousRole = "anonymous"
+
+// RBAC策略，角色对应允许调用的方法
+// 方法支持通配符，如 /proto.ArticleService/* 表示服务下的所有方法，* 表示所有方法
+type Policy struct {
+	Roles map[string][]string `json:"roles"`
+}
+
+func LoadPolicy(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Policy{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	for _, patterns := range p.Roles {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return p, nil
+}
+
+// 任意一个角色允许调用即可
+func (p *Policy) Allowed(roles []string, method string) bool {
+	for _, role := range roles {
+		for _, pattern := range p.Roles[role] {
+			if pattern == "*" {
+				return true
+			}
+			if ok, _ := path.Match(pattern, method); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
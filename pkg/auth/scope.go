@@ -0,0 +1,40 @@
+package auth
+
+import (
+	pb "github.com/lackone/grpc-study/proto"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"strings"
+)
+
+// 读取proto方法上声明的(proto.scopes)，fullMethod格式为 /proto.ArticleService/GetArticleList
+func MethodScopes(fullMethod string) []string {
+	name := strings.Replace(strings.TrimPrefix(fullMethod, "/"), "/", ".", 1)
+
+	desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil
+	}
+	md, ok := desc.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil
+	}
+
+	scopes, _ := proto.GetExtension(md.Options(), pb.E_Scopes).([]string)
+	return scopes
+}
+
+// 是否拥有全部需要的scope
+func HasScopes(granted, required []string) bool {
+	set := make(map[string]bool, len(granted))
+	for _, s := range granted {
+		set[s] = true
+	}
+	for _, s := range required {
+		if !set[s] {
+			return false
+		}
+	}
+	return true
+}
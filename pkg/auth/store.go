@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/lackone/grpc-study/pkg/model"
+	"gorm.io/gorm"
+	"os"
+	"strings"
+	"sync"
+)
+
+var ErrCredentialNotFound = errors.New("credential not found")
+
+type Credential struct {
+	AppKey    string   `json:"app_key"`
+	AppSecret string   `json:"app_secret"`
+	Name      string   `json:"name"`
+	Roles     []string `json:"roles"`
+}
+
+func (c *Credential) Identity() *Identity {
+	return &Identity{
+		AppKey: c.AppKey,
+		Name:   c.Name,
+		Roles:  c.Roles,
+	}
+}
+
+// 凭证存储，可以是文件、数据库等
+type CredentialStore interface {
+	Get(ctx context.Context, appKey string) (*Credential, error)
+}
+
+// 从json文件加载的凭证
+type FileStore struct {
+	mu          sync.RWMutex
+	file        string
+	credentials map[string]*Credential
+}
+
+func NewFileStore(file string) (*FileStore, error) {
+	s := &FileStore{file: file}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// 重新读取文件
+func (s *FileStore) Reload() error {
+	data, err := os.ReadFile(s.file)
+	if err != nil {
+		return err
+	}
+
+	var list []*Credential
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+
+	credentials := make(map[string]*Credential, len(list))
+	for _, c := range list {
+		credentials[c.AppKey] = c
+	}
+
+	s.mu.Lock()
+	s.credentials = credentials
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *FileStore) Get(ctx context.Context, appKey string) (*Credential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.credentials[appKey]
+	if !ok {
+		return nil, ErrCredentialNotFound
+	}
+	return c, nil
+}
+
+// 从数据库app表读取的凭证
+type DBStore struct {
+	db *gorm.DB
+}
+
+func NewDBStore(db *gorm.DB) *DBStore {
+	return &DBStore{db: db}
+}
+
+func (s *DBStore) Get(ctx context.Context, appKey string) (*Credential, error) {
+	var app model.App
+	err := s.db.WithContext(ctx).Where("app_key = ?", appKey).First(&app).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCredentialNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var roles []string
+	if app.Roles != "" {
+		roles = strings.Split(app.Roles, ",")
+	}
+
+	return &Credential{
+		AppKey:    app.AppKey,
+		AppSecret: app.AppSecret,
+		Name:      app.Name,
+		Roles:     roles,
+	}, nil
+}
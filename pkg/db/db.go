@@ -3,6 +3,7 @@ package db
 import (
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	"time"
 )
 
 var (
@@ -16,5 +17,10 @@ func InitDB() *gorm.DB {
 		panic(err)
 	}
 	//db.Logger = logger.Default.LogMode(logger.Info)
+
+	//每条sql记录一个span，超过200ms记录慢查询事件
+	if err := db.Use(NewTracingPlugin(WithDBName("test"), WithSlowThreshold(200*time.Millisecond))); err != nil {
+		panic(err)
+	}
 	return db
 }
@@ -0,0 +1,160 @@
+package db
+
+import (
+	"errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	tracingSpanKey  = "otel:span"
+	tracingBeginKey = "otel:begin"
+)
+
+type TracingOption func(*TracingPlugin)
+
+// 执行时间超过该值时在span上记录slow_query事件，0表示不记录
+func WithSlowThreshold(d time.Duration) TracingOption {
+	return func(p *TracingPlugin) {
+		p.slowThreshold = d
+	}
+}
+
+// 数据库名称，记录在db.name属性上
+func WithDBName(name string) TracingOption {
+	return func(p *TracingPlugin) {
+		p.dbName = name
+	}
+}
+
+// 每条sql创建一个子span，查询需要使用db.DB.WithContext(ctx)传入请求的ctx
+type TracingPlugin struct {
+	tracer        trace.Tracer
+	slowThreshold time.Duration
+	dbName        string
+}
+
+func NewTracingPlugin(opts ...TracingOption) *TracingPlugin {
+	p := &TracingPlugin{
+		tracer: otel.Tracer("github.com/lackone/grpc-study/pkg/db"),
+	}
+	for _, fn := range opts {
+		fn(p)
+	}
+	return p
+}
+
+func (p *TracingPlugin) Name() string {
+	return "tracing"
+}
+
+func (p *TracingPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	hooks := []struct {
+		name      string
+		before    func(string, func(*gorm.DB)) error
+		after     func(string, func(*gorm.DB)) error
+		operation string
+	}{
+		{"create", cb.Create().Before("gorm:create").Register, cb.Create().After("gorm:create").Register, "INSERT"},
+		{"query", cb.Query().Before("gorm:query").Register, cb.Query().After("gorm:query").Register, "SELECT"},
+		{"update", cb.Update().Before("gorm:update").Register, cb.Update().After("gorm:update").Register, "UPDATE"},
+		{"delete", cb.Delete().Before("gorm:delete").Register, cb.Delete().After("gorm:delete").Register, "DELETE"},
+		{"row", cb.Row().Before("gorm:row").Register, cb.Row().After("gorm:row").Register, ""},
+		{"raw", cb.Raw().Before("gorm:raw").Register, cb.Raw().After("gorm:raw").Register, ""},
+	}
+
+	for _, h := range hooks {
+		if err := h.before("tracing:before_"+h.name, p.before(h.operation)); err != nil {
+			return err
+		}
+		if err := h.after("tracing:after_"+h.name, p.after); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *TracingPlugin) before(operation string) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		if tx.Statement.Context == nil {
+			return
+		}
+
+		name := "gorm"
+		if operation != "" {
+			name += "." + strings.ToLower(operation)
+		}
+
+		attrs := []attribute.KeyValue{semconv.DBSystemMySQL}
+		if operation != "" {
+			attrs = append(attrs, semconv.DBOperation(operation))
+		}
+		if p.dbName != "" {
+			attrs = append(attrs, semconv.DBName(p.dbName))
+		}
+
+		ctx, span := p.tracer.Start(tx.Statement.Context, name,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(attrs...),
+		)
+		tx.Statement.Context = ctx
+		tx.InstanceSet(tracingSpanKey, span)
+		tx.InstanceSet(tracingBeginKey, time.Now())
+	}
+}
+
+func (p *TracingPlugin) after(tx *gorm.DB) {
+	v, ok := tx.InstanceGet(tracingSpanKey)
+	if !ok {
+		return
+	}
+	span := v.(trace.Span)
+	defer span.End()
+
+	if table := tx.Statement.Table; table != "" {
+		span.SetAttributes(semconv.DBSQLTableKey.String(table))
+	}
+	span.SetAttributes(
+		semconv.DBStatement(SanitizeSQL(tx.Statement.SQL.String())),
+		attribute.Int64("db.rows_affected", tx.RowsAffected),
+	)
+
+	//没有查到记录不算错误
+	if err := tx.Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	if p.slowThreshold <= 0 {
+		return
+	}
+	if v, ok := tx.InstanceGet(tracingBeginKey); ok {
+		if d := time.Since(v.(time.Time)); d >= p.slowThreshold {
+			span.AddEvent("slow_query", trace.WithAttributes(
+				attribute.Int64("db.duration_ms", d.Milliseconds()),
+				attribute.Int64("db.slow_threshold_ms", p.slowThreshold.Milliseconds()),
+			))
+		}
+	}
+}
+
+var (
+	sqlStringRe = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'|"(?:[^"\\]|\\.)*"`)
+	sqlNumberRe = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+	sqlSpaceRe  = regexp.MustCompile(`\s+`)
+)
+
+// 去掉sql中的字符串和数字常量，参数已经是占位符的保持不变
+func SanitizeSQL(sql string) string {
+	sql = sqlStringRe.ReplaceAllString(sql, "?")
+	sql = sqlNumberRe.ReplaceAllString(sql, "?")
+	return strings.TrimSpace(sqlSpaceRe.ReplaceAllString(sql, " "))
+}
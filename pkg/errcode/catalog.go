@@ -0,0 +1,126 @@
+package errcode
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"strings"
+)
+
+// 错误码目录中的一项
+type CatalogEntry struct {
+	Code       int    `json:"code"`
+	Message    string `json:"message"`
+	GRPCCode   string `json:"grpc_code"`
+	HTTPStatus int    `json:"http_status"`
+	Module     string `json:"module"`
+}
+
+// 导出所有已注册的错误码
+func Catalog() []CatalogEntry {
+	errs := Errors()
+	entries := make([]CatalogEntry, 0, len(errs))
+	for _, e := range errs {
+		rpcCode := ToRPCCode(e.Code())
+		entries = append(entries, CatalogEntry{
+			Code:       e.Code(),
+			Message:    e.Msg(),
+			GRPCCode:   rpcCode.String(),
+			HTTPStatus: runtime.HTTPStatusFromCode(rpcCode),
+			Module:     e.Module(),
+		})
+	}
+	return entries
+}
+
+func CatalogJSON(entries []CatalogEntry) ([]byte, error) {
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func CatalogMarkdown(entries []CatalogEntry) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("| 错误码 | 错误信息 | gRPC状态码 | HTTP状态码 | 模块 |\n")
+	buf.WriteString("| --- | --- | --- | --- | --- |\n")
+	for _, e := range entries {
+		msg := strings.ReplaceAll(e.Message, "|", "\\|")
+		fmt.Fprintf(&buf, "| %d | %s | %s | %d | %s |\n", e.Code, msg, e.GRPCCode, e.HTTPStatus, e.Module)
+	}
+	return buf.Bytes()
+}
+
+// 生成OpenAPI的components片段，可以合并到swagger文档中
+func CatalogOpenAPI(entries []CatalogEntry) ([]byte, error) {
+	codes := make([]int, 0, len(entries))
+	examples := map[string]interface{}{}
+	var desc strings.Builder
+	for _, e := range entries {
+		codes = append(codes, e.Code)
+		fmt.Fprintf(&desc, "* `%d` - %s (%s, HTTP %d)\n", e.Code, e.Message, e.Module, e.HTTPStatus)
+		examples[fmt.Sprintf("E%d", e.Code)] = map[string]interface{}{
+			"summary": e.Message,
+			"value": map[string]interface{}{
+				"code":    e.Code,
+				"message": e.Message,
+			},
+		}
+	}
+
+	fragment := map[string]interface{}{
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"ErrorCode": map[string]interface{}{
+					"type":        "integer",
+					"format":      "int32",
+					"enum":        codes,
+					"description": desc.String(),
+				},
+				"Error": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"code": map[string]interface{}{
+							"$ref": "#/components/schemas/ErrorCode",
+						},
+						"message": map[string]interface{}{
+							"type": "string",
+						},
+					},
+				},
+			},
+			"examples": examples,
+		},
+	}
+
+	b, err := json.MarshalIndent(fragment, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// 对比已提交的错误码目录，错误码被删除或者重新编号时返回问题列表
+func CheckCatalog(committed, current []CatalogEntry) []string {
+	codes := map[int]CatalogEntry{}
+	messages := map[string]int{}
+	for _, e := range current {
+		codes[e.Code] = e
+		messages[e.Message] = e.Code
+	}
+
+	var problems []string
+	for _, e := range committed {
+		if _, ok := codes[e.Code]; ok {
+			continue
+		}
+		if code, ok := messages[e.Message]; ok {
+			problems = append(problems, fmt.Sprintf("错误码 %d (%s) 被重新编号为 %d", e.Code, e.Message, code))
+		} else {
+			problems = append(problems, fmt.Sprintf("错误码 %d (%s) 被删除", e.Code, e.Message))
+		}
+	}
+	return problems
+}
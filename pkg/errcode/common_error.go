@@ -11,4 +11,5 @@ var (
 	AccessDenied     = NewError(10000006, "访问被拒绝")
 	LimitExceed      = NewError(10000007, "访问限制")
 	MethodNotAllowed = NewError(10000008, "不支持该方法")
+	Unavailable      = NewError(10000009, "服务繁忙，请稍后重试")
 )
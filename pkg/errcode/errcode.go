@@ -1,20 +1,50 @@
 package errcode
 
-import "fmt"
+import (
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/anypb"
+	"sort"
+)
 
 type Error struct {
-	code int
-	msg  string
+	code    int
+	msg     string
+	details []interface{}
+
+	//从grpc错误还原时保留原始的状态码和proto.Error中的detail，重新返回时不会丢失
+	rpcCode codes.Code
+	detail  *anypb.Any
 }
 
-var _codes = map[int]string{}
+var _codes = map[int]*Error{}
+
+// 错误码前四位为模块编号
+var _modules = map[int]string{
+	0:    "common",
+	1000: "common",
+	2001: "article",
+}
 
 func NewError(code int, msg string) *Error {
 	if _, ok := _codes[code]; ok {
 		panic(fmt.Sprintf("错误码 %d 已经存在，请更换一个", code))
 	}
-	_codes[code] = msg
-	return &Error{code: code, msg: msg}
+	e := &Error{code: code, msg: msg}
+	_codes[code] = e
+	return e
+}
+
+// 所有通过NewError注册的错误，按错误码排序
+func Errors() []*Error {
+	errs := make([]*Error, 0, len(_codes))
+	for _, e := range _codes {
+		errs = append(errs, e)
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].code < errs[j].code
+	})
+	return errs
 }
 
 func (e *Error) Error() string {
@@ -28,3 +58,30 @@ func (e *Error) Code() int {
 func (e *Error) Msg() string {
 	return e.msg
 }
+
+func (e *Error) Details() []interface{} {
+	return e.details
+}
+
+// 返回附带详情的新错误，不会修改注册的错误
+func (e *Error) WithDetails(details ...interface{}) *Error {
+	newError := *e
+	newError.details = append(append([]interface{}{}, e.details...), details...)
+	return &newError
+}
+
+// 支持errors.Is按错误码比较
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return e.code == t.code
+}
+
+func (e *Error) Module() string {
+	if name, ok := _modules[e.code/10000]; ok {
+		return name
+	}
+	return "unknown"
+}
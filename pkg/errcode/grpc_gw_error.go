@@ -2,44 +2,206 @@ package errcode
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/lackone/grpc-study/pkg/requestid"
 	"github.com/lackone/grpc-study/proto"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/grpclog"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/anypb"
+	"io"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
-type HttpError struct {
-	Code    int32  `json:"code,omitempty"`
-	Message string `json:"message,omitempty"`
+const problemContentType = "application/problem+json"
+
+// 401时返回的RFC 7235质询，错误信息可能包含非ASCII字符，不能作为质询
+const authChallenge = `Bearer realm="grpc-study"`
+
+type GatewayErrorOption func(*gatewayError)
+
+type gatewayError struct {
+	problem       bool
+	headerMatcher runtime.HeaderMatcherFunc
+}
+
+// 总是返回RFC 7807格式的错误，否则只有Accept包含application/problem+json时才返回
+func WithProblemDetails() GatewayErrorOption {
+	return func(g *gatewayError) {
+		g.problem = true
+	}
+}
+
+// 和runtime.WithOutgoingHeaderMatcher保持一致，决定哪些grpc header metadata转发到HTTP响应头
+func WithOutgoingHeaderMatcher(fn runtime.HeaderMatcherFunc) GatewayErrorOption {
+	return func(g *gatewayError) {
+		g.headerMatcher = fn
+	}
+}
+
+func NewGrpcGatewayError(opts ...GatewayErrorOption) runtime.ErrorHandlerFunc {
+	g := &gatewayError{headerMatcher: defaultHeaderMatcher}
+	for _, fn := range opts {
+		fn(g)
+	}
+	return g.handle
 }
 
-func GrpcGatewayError(ctx context.Context, _ *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, _ *http.Request, err error) {
+var defaultGatewayError = &gatewayError{headerMatcher: defaultHeaderMatcher}
+
+func defaultHeaderMatcher(key string) (string, bool) {
+	return runtime.MetadataHeaderPrefix + key, true
+}
+
+func GrpcGatewayError(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	defaultGatewayError.handle(ctx, mux, marshaler, w, r, err)
+}
+
+// 路由错误(404/405等)也转换成errcode错误，交给mux配置的错误处理函数
+func GrpcGatewayRoutingError(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, httpStatus int) {
+	var e *Error
+	switch httpStatus {
+	case http.StatusBadRequest:
+		e = InvalidParams
+	case http.StatusNotFound:
+		e = NotFound
+	case http.StatusMethodNotAllowed:
+		e = MethodNotAllowed
+	default:
+		e = Fail
+	}
+
+	runtime.HTTPError(ctx, mux, marshaler, w, r, &runtime.HTTPStatusError{
+		HTTPStatus: httpStatus,
+		Err:        TogRPCError(e),
+	})
+}
+
+func (g *gatewayError) handle(ctx context.Context, _ *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	const fallback = `{"code": 10000000, "message": "failed to marshal error message"}`
+
+	var customStatus *runtime.HTTPStatusError
+	if errors.As(err, &customStatus) {
+		err = customStatus.Err
+	}
+
 	s, ok := status.FromError(err)
 	if !ok {
 		s = status.New(codes.Unknown, err.Error())
 	}
 
-	pb := s.Proto()
+	httpStatus := runtime.HTTPStatusFromCode(s.Code())
+	if customStatus != nil {
+		httpStatus = customStatus.HTTPStatus
+	}
+
+	md, _ := runtime.ServerMetadataFromContext(ctx)
+
+	var retryAfter string
 
-	httpError := HttpError{
-		Code:    int32(s.Code()),
-		Message: s.Message(),
+	httpError := &proto.HttpError{
+		Code:      int32(FromRPCCode(s.Code()).Code()),
+		Message:   s.Message(),
+		RequestId: requestId(md, r),
+		TraceId:   traceId(ctx),
 	}
 
-	details := s.Details()
-	for _, detail := range details {
-		if v, ok := detail.(*proto.Error); ok {
+	for _, detail := range s.Details() {
+		switch v := detail.(type) {
+		case *proto.Error:
 			httpError.Code = v.Code
 			httpError.Message = v.Message
+			if v.Detail != nil {
+				httpError.Details = append(httpError.Details, v.Detail)
+			}
+		case protoreflect.ProtoMessage:
+			if ri, ok := v.(*errdetails.RetryInfo); ok && ri.RetryDelay != nil {
+				retryAfter = strconv.Itoa(int(math.Ceil(ri.RetryDelay.AsDuration().Seconds())))
+			}
+			if a, err := anypb.New(v); err == nil {
+				httpError.Details = append(httpError.Details, a)
+			}
+		case error:
+			grpclog.Infof("Failed to unmarshal error detail: %v", v)
 		}
 	}
 
-	contentType := marshaler.ContentType(pb)
+	var body interface{} = httpError
+	contentType := marshaler.ContentType(httpError)
+	if strings.HasPrefix(contentType, "application/json") && (g.problem || acceptsProblem(r)) {
+		body = toProblem(httpError, httpStatus, r)
+		contentType = problemContentType
+	}
+
+	w.Header().Del("Trailer")
+	w.Header().Del("Transfer-Encoding")
 	w.Header().Set("Content-Type", contentType)
+	if httpError.RequestId != "" {
+		w.Header().Set(requestid.Header, httpError.RequestId)
+	}
+	if retryAfter != "" {
+		w.Header().Set("Retry-After", retryAfter)
+	}
+	if s.Code() == codes.Unauthenticated {
+		w.Header().Set("WWW-Authenticate", authChallenge)
+	}
+	for k, vs := range md.HeaderMD {
+		if h, ok := g.headerMatcher(k); ok {
+			for _, v := range vs {
+				w.Header().Add(h, v)
+			}
+		}
+	}
+
+	buf, merr := marshaler.Marshal(body)
+	if merr != nil {
+		grpclog.Infof("Failed to marshal error message %q: %v", s, merr)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, fallback)
+		return
+	}
 
-	resp, _ := json.Marshal(httpError)
-	w.WriteHeader(runtime.HTTPStatusFromCode(s.Code()))
-	w.Write(resp)
+	w.WriteHeader(httpStatus)
+	w.Write(buf)
+}
+
+func toProblem(e *proto.HttpError, httpStatus int, r *http.Request) *proto.Problem {
+	return &proto.Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(httpStatus),
+		Status:    int32(httpStatus),
+		Detail:    e.Message,
+		Instance:  r.URL.Path,
+		Code:      e.Code,
+		RequestId: e.RequestId,
+		TraceId:   e.TraceId,
+		Details:   e.Details,
+	}
+}
+
+func acceptsProblem(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), problemContentType)
+}
+
+func requestId(md runtime.ServerMetadata, r *http.Request) string {
+	if vs := md.HeaderMD.Get(requestid.Header); len(vs) > 0 {
+		return vs[0]
+	}
+	return r.Header.Get(requestid.Header)
+}
+
+func traceId(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
 }
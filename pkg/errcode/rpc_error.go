@@ -1,19 +1,57 @@
 package errcode
 
 import (
+	"errors"
+	protov1 "github.com/golang/protobuf/proto"
 	pb "github.com/lackone/grpc-study/proto"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
 )
 
 func TogRPCError(err *Error) error {
-	s, _ := status.New(ToRPCCode(err.Code()), err.Msg()).WithDetails(&pb.Error{Code: int32(err.Code()), Message: err.Msg()})
-	return s.Err()
+	return err.GRPCStatus().Err()
+}
+
+// 实现GRPCStatus接口，可以直接作为grpc错误返回
+func (e *Error) GRPCStatus() *status.Status {
+	s := status.New(e.RPCCode(), e.Msg())
+	if s.Code() == codes.OK {
+		return s
+	}
+
+	details := []protov1.Message{&pb.Error{Code: int32(e.Code()), Message: e.Msg(), Detail: e.detail}}
+	for _, detail := range e.details {
+		if m, ok := detail.(proto.Message); ok {
+			details = append(details, protov1.MessageV1(m))
+		}
+	}
+
+	if ds, err := s.WithDetails(details...); err == nil {
+		s = ds
+	}
+	return s
+}
+
+// grpc状态码，从grpc错误还原的使用原始状态码，否则按错误码映射
+func (e *Error) RPCCode() codes.Code {
+	if e.rpcCode != codes.OK {
+		return e.rpcCode
+	}
+	return ToRPCCode(e.Code())
+}
+
+// proto.Error中的detail
+func (e *Error) Detail() *anypb.Any {
+	return e.detail
 }
 
 func ToRPCCode(code int) codes.Code {
 	var statusCode codes.Code
 	switch code {
+	case Success.Code():
+		statusCode = codes.OK
 	case Fail.Code():
 		statusCode = codes.Internal
 	case InvalidParams.Code():
@@ -30,6 +68,8 @@ func ToRPCCode(code int) codes.Code {
 		statusCode = codes.ResourceExhausted
 	case MethodNotAllowed.Code():
 		statusCode = codes.Unimplemented
+	case Unavailable.Code():
+		statusCode = codes.Unavailable
 	default:
 		statusCode = codes.Unknown
 	}
@@ -37,6 +77,70 @@ func ToRPCCode(code int) codes.Code {
 	return statusCode
 }
 
+func FromRPCCode(code codes.Code) *Error {
+	var err *Error
+	switch code {
+	case codes.OK:
+		err = Success
+	case codes.Internal:
+		err = Fail
+	case codes.InvalidArgument:
+		err = InvalidParams
+	case codes.Unauthenticated:
+		err = Unauthorized
+	case codes.PermissionDenied:
+		err = AccessDenied
+	case codes.DeadlineExceeded:
+		err = DeadlineExceeded
+	case codes.NotFound:
+		err = NotFound
+	case codes.ResourceExhausted:
+		err = LimitExceed
+	case codes.Unimplemented:
+		err = MethodNotAllowed
+	case codes.Unavailable:
+		err = Unavailable
+	default:
+		err = Unknown
+	}
+
+	return err
+}
+
+// 客户端从grpc错误中还原*Error，错误码和错误信息取自proto.Error详情，
+// 没有proto.Error时按状态码映射，保留原始状态码，如Canceled不会变成Unknown
+func FromRPCError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	s, _ := status.FromError(err)
+
+	var (
+		pbErr   *pb.Error
+		details []interface{}
+	)
+	for _, detail := range s.Details() {
+		if v, ok := detail.(*pb.Error); ok && pbErr == nil {
+			pbErr = v
+			continue
+		}
+		details = append(details, detail)
+	}
+
+	if pbErr == nil {
+		e = FromRPCCode(s.Code())
+		return &Error{code: e.Code(), msg: s.Message(), details: details, rpcCode: s.Code()}
+	}
+
+	return &Error{code: int(pbErr.Code), msg: pbErr.Message, details: details, rpcCode: s.Code(), detail: pbErr.Detail}
+}
+
 type Status struct {
 	*status.Status
 }
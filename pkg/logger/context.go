@@ -0,0 +1,86 @@
+package logger
+
+import (
+	"context"
+	"github.com/lackone/grpc-study/pkg/requestid"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"sync"
+)
+
+type contextKey struct{}
+
+// 请求级别的logger和附加字段，拦截器链外层的日志也能看到内层(如认证)添加的字段
+type requestLogger struct {
+	logger *zap.Logger
+
+	mu     sync.Mutex
+	keys   []string
+	values map[string]string
+}
+
+// 把logger放到ctx中，请求处理过程中通过Ctx获取
+func NewContext(ctx context.Context, l *zap.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, &requestLogger{logger: l, values: map[string]string{}})
+}
+
+// ctx中没有logger时返回Default()
+func FromContext(ctx context.Context) *zap.Logger {
+	if r, ok := ctx.Value(contextKey{}).(*requestLogger); ok && r.logger != nil {
+		return r.logger
+	}
+	return Default()
+}
+
+// 给当前请求的日志添加字段，如认证后的调用方，需要先通过NewContext创建
+func Annotate(ctx context.Context, key, value string) {
+	r, ok := ctx.Value(contextKey{}).(*requestLogger)
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.values[key]; !ok {
+		r.keys = append(r.keys, key)
+	}
+	r.values[key] = value
+}
+
+// 返回ctx中的logger，并带上trace_id、span_id、request_id、method和Annotate添加的字段
+func Ctx(ctx context.Context) *zap.Logger {
+	return WithContext(ctx, FromContext(ctx))
+}
+
+// 给指定的logger带上ctx中的请求信息，字段在调用时读取
+func WithContext(ctx context.Context, l *zap.Logger) *zap.Logger {
+	return l.With(Fields(ctx)...)
+}
+
+func Fields(ctx context.Context) []zap.Field {
+	var fields []zap.Field
+
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		fields = append(fields,
+			zap.String("trace_id", sc.TraceID().String()),
+			zap.String("span_id", sc.SpanID().String()),
+		)
+	}
+	if id := requestid.FromContext(ctx); id != "" {
+		fields = append(fields, zap.String("request_id", id))
+	}
+	if method, ok := grpc.Method(ctx); ok {
+		fields = append(fields, zap.String("method", method))
+	}
+
+	if r, ok := ctx.Value(contextKey{}).(*requestLogger); ok {
+		r.mu.Lock()
+		for _, k := range r.keys {
+			fields = append(fields, zap.String(k, r.values[k]))
+		}
+		r.mu.Unlock()
+	}
+	return fields
+}
@@ -0,0 +1,75 @@
+package logger
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"os"
+	"sync"
+)
+
+type Config struct {
+	//日志级别：debug, info, warn, error
+	Level string
+	//输出：stdout, stderr 或者文件路径，文件会按大小切割
+	Output string
+	//单个文件最大大小(MB)
+	MaxSize int
+	//保留旧文件的最大个数
+	MaxBackups int
+	//保留旧文件的最大天数
+	MaxAge   int
+	Compress bool
+}
+
+var (
+	defaultLogger *zap.Logger
+	defaultOnce   sync.Once
+)
+
+// 默认输出JSON到标准输出
+func Default() *zap.Logger {
+	defaultOnce.Do(func() {
+		defaultLogger, _ = New(Config{})
+	})
+	return defaultLogger
+}
+
+func New(c Config) (*zap.Logger, error) {
+	level := zapcore.InfoLevel
+	if c.Level != "" {
+		if err := level.UnmarshalText([]byte(c.Level)); err != nil {
+			return nil, err
+		}
+	}
+
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.TimeKey = "time"
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), writer(c), level)
+	return zap.New(core, zap.AddCaller()), nil
+}
+
+func writer(c Config) zapcore.WriteSyncer {
+	switch c.Output {
+	case "", "stdout":
+		return zapcore.Lock(os.Stdout)
+	case "stderr":
+		return zapcore.Lock(os.Stderr)
+	}
+
+	maxSize := c.MaxSize
+	if maxSize <= 0 {
+		maxSize = 100
+	}
+
+	return zapcore.AddSync(&lumberjack.Logger{
+		Filename:   c.Output,
+		MaxSize:    maxSize,
+		MaxBackups: c.MaxBackups,
+		MaxAge:     c.MaxAge,
+		Compress:   c.Compress,
+		LocalTime:  true,
+	})
+}
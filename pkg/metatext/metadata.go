@@ -1,30 +1,77 @@
 package metatext
 
 import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 	"google.golang.org/grpc/metadata"
+	"net/http"
 	"strings"
 )
 
+// 实现propagation.TextMapCarrier，用于在grpc metadata中传递traceparent、baggage
 type MetadataTextMap struct {
 	metadata.MD
 }
 
+var _ propagation.TextMapCarrier = MetadataTextMap{}
+
 func (m MetadataTextMap) Get(key string) string {
-	if vs, ok := m.MD[key]; ok {
+	if vs := m.MD.Get(key); len(vs) > 0 {
 		return vs[0]
 	}
 	return ""
 }
 
+// 覆盖已有的值，重复注入时不会出现多个traceparent
 func (m MetadataTextMap) Set(key string, value string) {
 	key = strings.ToLower(key)
-	m.MD.Append(key, value)
+	m.MD.Set(key, value)
 }
 
 func (m MetadataTextMap) Keys() []string {
-	keys := make([]string, 0)
-	for k, _ := range m.MD {
+	keys := make([]string, 0, len(m.MD))
+	for k := range m.MD {
 		keys = append(keys, k)
 	}
 	return keys
 }
+
+// 把ctx中的span context和baggage写入md
+func Inject(ctx context.Context, md metadata.MD) {
+	otel.GetTextMapPropagator().Inject(ctx, MetadataTextMap{md})
+}
+
+// 从md中读取上游的span context和baggage
+func Extract(ctx context.Context, md metadata.MD) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, MetadataTextMap{md})
+}
+
+// 注入到outgoing metadata，调用下游grpc服务前使用
+func NewOutgoingContext(ctx context.Context) context.Context {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	Inject(ctx, md)
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// 从incoming metadata中提取，服务端没有使用otelgrpc拦截器时使用
+func FromIncomingContext(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	return Extract(ctx, md)
+}
+
+// grpc-gateway的metadata注解器，把otelhttp创建的span context传给grpc服务端，
+// 默认的header匹配规则不会转发traceparent
+func GatewayMetadata(ctx context.Context, r *http.Request) metadata.MD {
+	md := metadata.MD{}
+	Inject(ctx, md)
+	return md
+}
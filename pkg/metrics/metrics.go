@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+// 独立的注册表，不使用prometheus默认的全局注册表
+var Registry = prometheus.NewRegistry()
+
+var (
+	//grpc服务端指标，grpc_type为unary/client_stream/server_stream/bidi_stream
+	GrpcServerHandled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_server_handled_total",
+		Help: "Total number of RPCs completed on the server, regardless of success or failure.",
+	}, []string{"grpc_type", "grpc_method", "grpc_code"})
+
+	GrpcServerHandlingSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_server_handling_seconds",
+		Help:    "Histogram of response latency (seconds) of RPCs handled by the server.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"grpc_type", "grpc_method", "grpc_code"})
+
+	GrpcServerInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "grpc_server_in_flight",
+		Help: "Number of RPCs currently being handled by the server.",
+	}, []string{"grpc_type", "grpc_method"})
+
+	GrpcServerMsgReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_server_msg_received_total",
+		Help: "Total number of stream messages received from the client.",
+	}, []string{"grpc_type", "grpc_method"})
+
+	GrpcServerMsgSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_server_msg_sent_total",
+		Help: "Total number of stream messages sent by the server.",
+	}, []string{"grpc_type", "grpc_method"})
+
+	//HTTP指标，handler区分不同的处理器，如gateway
+	HttpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Total number of HTTP requests.",
+	}, []string{"handler", "method", "code"})
+
+	HttpRequestSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Histogram of HTTP request latency (seconds).",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"handler", "method", "code"})
+
+	HttpInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "Number of HTTP requests currently being served.",
+	}, []string{"handler"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		GrpcServerHandled,
+		GrpcServerHandlingSeconds,
+		GrpcServerInFlight,
+		GrpcServerMsgReceived,
+		GrpcServerMsgSent,
+		HttpRequests,
+		HttpRequestSeconds,
+		HttpInFlight,
+	)
+}
+
+// /metrics接口
+func Handler() http.Handler {
+	return promhttp.InstrumentMetricHandler(Registry, promhttp.HandlerFor(Registry, promhttp.HandlerOpts{
+		Registry: Registry,
+	}))
+}
+
+// 统计HTTP请求数、延迟和正在处理的请求数
+func InstrumentHandler(name string, h http.Handler) http.Handler {
+	labels := prometheus.Labels{"handler": name}
+	counter := HttpRequests.MustCurryWith(labels)
+	duration := HttpRequestSeconds.MustCurryWith(labels)
+
+	return promhttp.InstrumentHandlerInFlight(HttpInFlight.With(labels),
+		promhttp.InstrumentHandlerDuration(duration,
+			promhttp.InstrumentHandlerCounter(counter, h),
+		),
+	)
+}
+
+// 注册数据库连接池指标，dbName用于区分多个数据库
+func RegisterDB(db *gorm.DB, dbName string) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return Registry.Register(collectors.NewDBStatsCollector(sqlDB, dbName))
+}
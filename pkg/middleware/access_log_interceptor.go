@@ -2,20 +2,205 @@ package middleware
 
 import (
 	"context"
-	"fmt"
+	"encoding/json"
+	"github.com/lackone/grpc-study/pkg/errcode"
+	"github.com/lackone/grpc-study/pkg/logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"math/rand"
+	"strings"
 	"time"
 )
 
-func AccessLog(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	requestLog := "access request log: method: %s, begin_time: %d, request: %v\n"
-	beginTime := time.Now().Local().Unix()
-	fmt.Printf(requestLog, info.FullMethod, beginTime, req)
+const redacted = "******"
 
-	resp, err := handler(ctx, req)
+type AccessLogOption func(*accessLog)
 
-	responseLog := "access response log: method: %s, begin_time: %d, end_time: %d, response: %v\n"
-	endTime := time.Now().Local().Unix()
-	fmt.Printf(responseLog, info.FullMethod, beginTime, endTime, resp)
-	return resp, err
+type accessLog struct {
+	logger     *zap.Logger
+	payload    bool
+	redact     map[string]bool
+	sampleRate float64
+}
+
+func WithAccessLogger(l *zap.Logger) AccessLogOption {
+	return func(a *accessLog) {
+		a.logger = l
+	}
+}
+
+// 记录请求和响应内容，fields中的字段(proto字段名)会被脱敏
+func WithPayload(fields ...string) AccessLogOption {
+	return func(a *accessLog) {
+		a.payload = true
+		for _, f := range fields {
+			a.redact[strings.ToLower(f)] = true
+		}
+	}
+}
+
+// 成功请求的采样率(0~1)，出错的请求总是记录
+func WithSampleRate(rate float64) AccessLogOption {
+	return func(a *accessLog) {
+		a.sampleRate = rate
+	}
+}
+
+func newAccessLog(opts ...AccessLogOption) *accessLog {
+	a := &accessLog{
+		redact:     map[string]bool{},
+		sampleRate: 1,
+	}
+	for _, fn := range opts {
+		fn(a)
+	}
+	if a.logger == nil {
+		a.logger = logger.Default()
+	}
+	return a
+}
+
+func AccessLog(opts ...AccessLogOption) grpc.UnaryServerInterceptor {
+	a := newAccessLog(opts...)
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		beginTime := time.Now()
+
+		resp, err := handler(ctx, req)
+
+		if !a.sampled(err) {
+			return resp, err
+		}
+
+		fields := a.fields(ctx, beginTime, err)
+		fields = append(fields,
+			zap.Int("request_bytes", messageSize(req)),
+			zap.Int("response_bytes", messageSize(resp)),
+		)
+		if a.payload {
+			fields = append(fields,
+				zap.Any("request", a.redactPayload(req)),
+				zap.Any("response", a.redactPayload(resp)),
+			)
+		}
+
+		logger.WithContext(ctx, a.logger).Log(logLevel(err), "access log", fields...)
+		return resp, err
+	}
+}
+
+func StreamAccessLog(opts ...AccessLogOption) grpc.StreamServerInterceptor {
+	a := newAccessLog(opts...)
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		stream := newCountingServerStream(ss)
+
+		err := handler(srv, stream)
+
+		if !a.sampled(err) {
+			return err
+		}
+
+		fields := a.fields(stream.Context(), stream.beginTime, err)
+		fields = append(fields,
+			zap.Int64("messages_received", stream.received.Load()),
+			zap.Int64("messages_sent", stream.sent.Load()),
+			zap.Int64("request_bytes", stream.receivedBytes.Load()),
+			zap.Int64("response_bytes", stream.sentBytes.Load()),
+			zap.Bool("client_stream", info.IsClientStream),
+			zap.Bool("server_stream", info.IsServerStream),
+		)
+
+		logger.WithContext(stream.Context(), a.logger).Log(logLevel(err), "access log", fields...)
+		return err
+	}
+}
+
+func (a *accessLog) sampled(err error) bool {
+	if err != nil || a.sampleRate >= 1 {
+		return true
+	}
+	return rand.Float64() < a.sampleRate
+}
+
+func (a *accessLog) fields(ctx context.Context, beginTime time.Time, err error) []zap.Field {
+	s, _ := status.FromError(err)
+
+	//method、trace_id等请求信息由logger.WithContext添加
+	fields := []zap.Field{
+		zap.Float64("duration_ms", float64(time.Since(beginTime).Microseconds())/1000),
+		zap.String("code", s.Code().String()),
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		fields = append(fields, zap.String("peer", p.Addr.String()))
+	}
+	if err != nil {
+		fields = append(fields,
+			zap.Int("errcode", errcode.FromRPCError(err).Code()),
+			zap.String("error", s.Message()),
+		)
+	}
+	return fields
+}
+
+func (a *accessLog) redactPayload(m interface{}) interface{} {
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return m
+	}
+
+	b, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+	if err != nil {
+		return m
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return m
+	}
+	if len(a.redact) > 0 {
+		a.redactValue(v)
+	}
+	return v
+}
+
+func (a *accessLog) redactValue(v interface{}) {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		for k, val := range vv {
+			if a.redact[strings.ToLower(k)] {
+				vv[k] = redacted
+				continue
+			}
+			a.redactValue(val)
+		}
+	case []interface{}:
+		for _, val := range vv {
+			a.redactValue(val)
+		}
+	}
+}
+
+func messageSize(m interface{}) int {
+	if msg, ok := m.(proto.Message); ok {
+		return proto.Size(msg)
+	}
+	return 0
+}
+
+func logLevel(err error) zapcore.Level {
+	if err == nil {
+		return zapcore.InfoLevel
+	}
+	switch status.Code(err) {
+	case codes.Internal, codes.Unknown, codes.DataLoss, codes.Unavailable:
+		return zapcore.ErrorLevel
+	}
+	return zapcore.WarnLevel
 }
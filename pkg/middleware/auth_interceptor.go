@@ -0,0 +1,341 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/lackone/grpc-study/pkg/auth"
+	"github.com/lackone/grpc-study/pkg/errcode"
+	"github.com/lackone/grpc-study/pkg/logger"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type AuthOption func(*authenticator)
+
+type authenticator struct {
+	store     auth.CredentialStore
+	skip      map[string]bool
+	skew      time.Duration
+	nonceSize int
+	nonces    *auth.NonceCache
+	jwt       *auth.JWTVerifier
+	scopes    map[string][]string
+}
+
+// 不需要认证的方法，可以是完整方法名，也可以是服务名前缀，如 /grpc.reflection.v1alpha.ServerReflection/
+func WithAuthSkip(methods ...string) AuthOption {
+	return func(a *authenticator) {
+		for _, m := range methods {
+			a.skip[m] = true
+		}
+	}
+}
+
+// 签名中时间戳允许的最大误差，默认5分钟
+func WithClockSkew(skew time.Duration) AuthOption {
+	return func(a *authenticator) {
+		a.skew = skew
+	}
+}
+
+// nonce缓存的最大个数，默认100000
+func WithNonceCacheSize(size int) AuthOption {
+	return func(a *authenticator) {
+		a.nonceSize = size
+	}
+}
+
+// HMAC认证时，带有Bearer token的请求改为JWT认证
+func WithJWTVerifier(verifier *auth.JWTVerifier) AuthOption {
+	return func(a *authenticator) {
+		a.jwt = verifier
+	}
+}
+
+// 方法需要的scope，优先于proto方法上声明的(proto.scopes)
+func WithRequiredScopes(scopes map[string][]string) AuthOption {
+	return func(a *authenticator) {
+		for method, s := range scopes {
+			a.scopes[method] = s
+		}
+	}
+}
+
+func newAuthenticator(store auth.CredentialStore, opts ...AuthOption) *authenticator {
+	a := &authenticator{store: store, skip: map[string]bool{}, skew: 5 * time.Minute, nonceSize: 100000, scopes: map[string][]string{}}
+	for _, fn := range opts {
+		fn(a)
+	}
+	a.nonces = auth.NewNonceCache(a.nonceSize, 2*a.skew)
+	return a
+}
+
+// 校验客户端通过metadata传过来的app_key和app_secret
+func Auth(store auth.CredentialStore, opts ...AuthOption) grpc.UnaryServerInterceptor {
+	a := newAuthenticator(store, opts...)
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if a.skipped(info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		ctx, err := a.authenticate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func StreamAuth(store auth.CredentialStore, opts ...AuthOption) grpc.StreamServerInterceptor {
+	a := newAuthenticator(store, opts...)
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if a.skipped(info.FullMethod) {
+			return handler(srv, ss)
+		}
+
+		ctx, err := a.authenticate(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextServerStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// 校验客户端auth.HMACSigner生成的签名，拒绝时间戳超出误差和重复nonce的请求
+func HMACAuth(store auth.CredentialStore, opts ...AuthOption) grpc.UnaryServerInterceptor {
+	a := newAuthenticator(store, opts...)
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if a.skipped(info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		ctx, err := a.authenticateHMAC(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func StreamHMACAuth(store auth.CredentialStore, opts ...AuthOption) grpc.StreamServerInterceptor {
+	a := newAuthenticator(store, opts...)
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if a.skipped(info.FullMethod) {
+			return handler(srv, ss)
+		}
+
+		ctx, err := a.authenticateHMAC(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextServerStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// 校验authorization中的Bearer JWT，并检查方法需要的scope
+func JWTAuth(verifier *auth.JWTVerifier, opts ...AuthOption) grpc.UnaryServerInterceptor {
+	a := newAuthenticator(nil, opts...)
+	a.jwt = verifier
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if a.skipped(info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		ctx, err := a.authenticateJWT(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func StreamJWTAuth(verifier *auth.JWTVerifier, opts ...AuthOption) grpc.StreamServerInterceptor {
+	a := newAuthenticator(nil, opts...)
+	a.jwt = verifier
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if a.skipped(info.FullMethod) {
+			return handler(srv, ss)
+		}
+
+		ctx, err := a.authenticateJWT(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextServerStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func (a *authenticator) skipped(method string) bool {
+	if a.skip[method] {
+		return true
+	}
+	if i := strings.LastIndex(method, "/"); i > 0 {
+		return a.skip[method[:i+1]]
+	}
+	return false
+}
+
+func (a *authenticator) authenticate(ctx context.Context) (context.Context, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, errcode.Unauthorized
+	}
+
+	appKey := firstValue(md, "app_key")
+	appSecret := firstValue(md, "app_secret")
+	if appKey == "" || appSecret == "" {
+		return nil, errcode.Unauthorized
+	}
+
+	c, err := a.store.Get(ctx, appKey)
+	if errors.Is(err, auth.ErrCredentialNotFound) {
+		return nil, errcode.Unauthorized
+	}
+	if err != nil {
+		logger.Ctx(ctx).Error("get credential failed", zap.String("app_key", appKey), zap.Error(err))
+		return nil, errcode.Fail
+	}
+	if subtle.ConstantTimeCompare([]byte(c.AppSecret), []byte(appSecret)) != 1 {
+		return nil, errcode.Unauthorized
+	}
+
+	return withIdentity(ctx, c.Identity()), nil
+}
+
+func (a *authenticator) authenticateHMAC(ctx context.Context, method string) (context.Context, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, errcode.Unauthorized
+	}
+
+	if a.jwt != nil && len(md.Get("authorization")) > 0 {
+		return a.authenticateJWT(ctx, method)
+	}
+
+	appKey := firstValue(md, auth.AppKeyKey)
+	timestamp := firstValue(md, auth.TimestampKey)
+	nonce := firstValue(md, auth.NonceKey)
+	signature := firstValue(md, auth.SignatureKey)
+	if appKey == "" || timestamp == "" || nonce == "" || signature == "" {
+		return nil, errcode.Unauthorized
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, errcode.Unauthorized
+	}
+	if d := time.Since(time.Unix(ts, 0)); d > a.skew || d < -a.skew {
+		return nil, errcode.Unauthorized
+	}
+
+	c, err := a.store.Get(ctx, appKey)
+	if errors.Is(err, auth.ErrCredentialNotFound) {
+		return nil, errcode.Unauthorized
+	}
+	if err != nil {
+		logger.Ctx(ctx).Error("get credential failed", zap.String("app_key", appKey), zap.Error(err))
+		return nil, errcode.Fail
+	}
+	if !auth.VerifySignature(c.AppSecret, method, timestamp, nonce, signature) {
+		return nil, errcode.Unauthorized
+	}
+
+	//签名通过后再记录nonce，避免伪造的请求占满缓存
+	if err := a.nonces.Add(appKey + ":" + nonce); err != nil {
+		if errors.Is(err, auth.ErrNonceCacheFull) {
+			//无法判断是否重放，拒绝请求，客户端稍后重试
+			logger.Ctx(ctx).Warn("nonce cache full", zap.String("app_key", appKey))
+			return nil, errcode.LimitExceed
+		}
+		return nil, errcode.Unauthorized
+	}
+
+	return withIdentity(ctx, c.Identity()), nil
+}
+
+func (a *authenticator) authenticateJWT(ctx context.Context, method string) (context.Context, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, errcode.Unauthorized
+	}
+
+	//grpc-gateway会把HTTP的Authorization头转发到authorization
+	token := firstValue(md, "authorization")
+	if len(token) < 7 || !strings.EqualFold(token[:7], "bearer ") {
+		return nil, errcode.Unauthorized
+	}
+
+	claims, err := a.jwt.Verify(strings.TrimSpace(token[7:]))
+	if err != nil {
+		return nil, errcode.Unauthorized
+	}
+
+	required, ok := a.scopes[method]
+	if !ok {
+		required = auth.MethodScopes(method)
+	}
+	if !auth.HasScopes(claims.Scopes(), required) {
+		return nil, errcode.AccessDenied
+	}
+
+	return withIdentity(ctx, claims.Identity()), nil
+}
+
+// 认证通过后，请求的日志都会带上调用方
+func withIdentity(ctx context.Context, id *auth.Identity) context.Context {
+	if id.AppKey != "" {
+		logger.Annotate(ctx, "app_key", id.AppKey)
+	}
+	if id.Subject != "" {
+		logger.Annotate(ctx, "subject", id.Subject)
+	}
+	return auth.NewContext(ctx, id)
+}
+
+// HTTP调用方通过这些请求头传递自己的签名，gateway本身不带身份，
+// 没有JWT和签名的HTTP请求会被拒绝
+var gatewayAuthHeaders = map[string]string{
+	"X-App-Key":   auth.AppKeyKey,
+	"X-Timestamp": auth.TimestampKey,
+	"X-Nonce":     auth.NonceKey,
+	"X-Signature": auth.SignatureKey,
+}
+
+// 用于runtime.WithIncomingHeaderMatcher，把签名请求头转发到grpc metadata，
+// 签名中的method为gateway调用的grpc方法，Authorization按默认规则转发
+func GatewayAuthHeaderMatcher(key string) (string, bool) {
+	if k, ok := gatewayAuthHeaders[textproto.CanonicalMIMEHeaderKey(key)]; ok {
+		return k, true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if vs := md.Get(key); len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+// 替换流的context
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextServerStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,340 @@
+package middleware
+
+import (
+	"context"
+	"github.com/lackone/grpc-study/pkg/errcode"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"sync"
+	"time"
+)
+
+type BreakerState int
+
+const (
+	StateClosed BreakerState = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+type BreakerOption func(*breakerGroup)
+
+type breakerGroup struct {
+	failureThreshold int
+	openTimeout      time.Duration
+	halfOpenRequests int
+	probeTimeout     time.Duration
+	failureCodes     map[codes.Code]bool
+	onStateChange    []func(method string, from, to BreakerState)
+
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+// 连续失败多少次后熔断，默认5
+func WithFailureThreshold(n int) BreakerOption {
+	return func(g *breakerGroup) {
+		g.failureThreshold = n
+	}
+}
+
+// 熔断后多久进入半开状态，默认10秒
+func WithOpenTimeout(d time.Duration) BreakerOption {
+	return func(g *breakerGroup) {
+		g.openTimeout = d
+	}
+}
+
+// 半开状态允许的探测请求数，全部成功后恢复，默认1
+func WithHalfOpenRequests(n int) BreakerOption {
+	return func(g *breakerGroup) {
+		g.halfOpenRequests = n
+	}
+}
+
+// 探测请求多久没有结果时重新探测，如流没有读取到结束，默认10秒
+func WithProbeTimeout(d time.Duration) BreakerOption {
+	return func(g *breakerGroup) {
+		g.probeTimeout = d
+	}
+}
+
+// 计为失败的状态码，默认Unknown, Internal, Unavailable, DeadlineExceeded, ResourceExhausted
+func WithFailureCodes(cs ...codes.Code) BreakerOption {
+	return func(g *breakerGroup) {
+		g.failureCodes = map[codes.Code]bool{}
+		for _, c := range cs {
+			g.failureCodes[c] = true
+		}
+	}
+}
+
+// 状态变化回调，可以用来记录日志、上报指标
+func WithStateChange(fn func(method string, from, to BreakerState)) BreakerOption {
+	return func(g *breakerGroup) {
+		g.onStateChange = append(g.onStateChange, fn)
+	}
+}
+
+func newBreakerGroup(opts ...BreakerOption) *breakerGroup {
+	g := &breakerGroup{
+		failureThreshold: 5,
+		openTimeout:      10 * time.Second,
+		halfOpenRequests: 1,
+		probeTimeout:     10 * time.Second,
+		failureCodes: map[codes.Code]bool{
+			codes.Unknown:           true,
+			codes.Internal:          true,
+			codes.Unavailable:       true,
+			codes.DeadlineExceeded:  true,
+			codes.ResourceExhausted: true,
+		},
+		breakers: map[string]*circuitBreaker{},
+	}
+	for _, fn := range opts {
+		fn(g)
+	}
+	return g
+}
+
+// 按方法熔断，放在grpc_retry之前，熔断时直接返回不会被重试，统计的是重试后的最终结果
+func UnaryClientBreaker(opts ...BreakerOption) grpc.UnaryClientInterceptor {
+	g := newBreakerGroup(opts...)
+
+	return func(ctx context.Context, method string, req, resp interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		b := g.breaker(method)
+		gen, ok := b.allow()
+		if !ok {
+			return breakerOpenError(method)
+		}
+
+		err := invoker(ctx, method, req, resp, cc, opts...)
+		g.done(b, gen, err)
+		return err
+	}
+}
+
+func StreamClientBreaker(opts ...BreakerOption) grpc.StreamClientInterceptor {
+	g := newBreakerGroup(opts...)
+
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		b := g.breaker(method)
+		gen, ok := b.allow()
+		if !ok {
+			return nil, breakerOpenError(method)
+		}
+
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			g.done(b, gen, err)
+			return nil, err
+		}
+
+		s := &breakerClientStream{ClientStream: stream, group: g, breaker: b, gen: gen, serverStreams: desc.ServerStreams}
+		go func() {
+			//调用方取消或超时时记录结果，正常结束由RecvMsg记录，没有读取结果的流由探测超时处理
+			<-stream.Context().Done()
+			if err := ctx.Err(); err != nil {
+				s.finish(status.FromContextError(err).Err())
+			}
+		}()
+		return s, nil
+	}
+}
+
+// 流在RecvMsg结束时才知道结果
+type breakerClientStream struct {
+	grpc.ClientStream
+	group         *breakerGroup
+	breaker       *circuitBreaker
+	gen           uint64
+	serverStreams bool
+	once          sync.Once
+}
+
+func (s *breakerClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == io.EOF || (err == nil && !s.serverStreams) {
+		//服务端不是流时，收到一个消息就结束了
+		s.finish(nil)
+	} else if err != nil {
+		s.finish(err)
+	}
+	return err
+}
+
+func (s *breakerClientStream) finish(err error) {
+	s.once.Do(func() { s.group.done(s.breaker, s.gen, err) })
+}
+
+func (g *breakerGroup) breaker(method string) *circuitBreaker {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if b, ok := g.breakers[method]; ok {
+		return b
+	}
+	b := &circuitBreaker{group: g, method: method}
+	g.breakers[method] = b
+	return b
+}
+
+// 记录请求结果，调用方取消的请求不知道服务端是否正常，只释放探测名额
+func (g *breakerGroup) done(b *circuitBreaker, gen uint64, err error) {
+	if status.Code(err) == codes.Canceled {
+		b.release(gen)
+		return
+	}
+	b.record(gen, !g.isFailure(err))
+}
+
+func (g *breakerGroup) isFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	return g.failureCodes[status.Code(err)]
+}
+
+func breakerOpenError(method string) error {
+	return errcode.Unavailable.WithDetails(&errdetails.ErrorInfo{
+		Reason:   "CIRCUIT_OPEN",
+		Metadata: map[string]string{"method": method},
+	})
+}
+
+type circuitBreaker struct {
+	group  *breakerGroup
+	method string
+
+	mu sync.Mutex
+	//状态
+	state BreakerState
+	//连续失败次数
+	failures int
+	openedAt time.Time
+	//半开状态已放行的探测请求数和成功数
+	probes     int
+	successes  int
+	halfOpenAt time.Time
+	//每次状态变化加1，状态变化前放行的请求结果不再统计
+	generation uint64
+}
+
+// 放行时返回当前的generation，记录结果时传回
+func (b *circuitBreaker) allow() (uint64, bool) {
+	b.mu.Lock()
+
+	from := b.state
+	allowed := true
+	switch b.state {
+	case StateOpen:
+		if time.Since(b.openedAt) < b.group.openTimeout {
+			allowed = false
+			break
+		}
+		b.setState(StateHalfOpen)
+		b.probes = 1
+	case StateHalfOpen:
+		if b.probes < b.group.halfOpenRequests {
+			b.probes++
+			break
+		}
+		//探测请求一直没有结果，重新开始一轮探测
+		if time.Since(b.halfOpenAt) < b.group.probeTimeout {
+			allowed = false
+			break
+		}
+		b.setState(StateHalfOpen)
+		b.probes = 1
+	}
+
+	gen := b.generation
+	to := b.state
+	b.mu.Unlock()
+
+	b.notify(from, to)
+	return gen, allowed
+}
+
+// 释放半开状态的探测名额
+func (b *circuitBreaker) release(gen uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if gen == b.generation && b.state == StateHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+func (b *circuitBreaker) record(gen uint64, success bool) {
+	b.mu.Lock()
+	if gen != b.generation {
+		b.mu.Unlock()
+		return
+	}
+
+	from := b.state
+	switch b.state {
+	case StateClosed:
+		if success {
+			b.failures = 0
+		} else {
+			b.failures++
+			if b.failures >= b.group.failureThreshold {
+				b.setState(StateOpen)
+			}
+		}
+	case StateHalfOpen:
+		if success {
+			b.successes++
+			if b.successes >= b.group.halfOpenRequests {
+				b.setState(StateClosed)
+			}
+		} else {
+			b.setState(StateOpen)
+		}
+	}
+
+	to := b.state
+	b.mu.Unlock()
+
+	b.notify(from, to)
+}
+
+func (b *circuitBreaker) setState(state BreakerState) {
+	b.state = state
+	b.generation++
+	b.failures = 0
+	b.probes = 0
+	b.successes = 0
+	switch state {
+	case StateOpen:
+		b.openedAt = time.Now()
+	case StateHalfOpen:
+		b.halfOpenAt = time.Now()
+	}
+}
+
+func (b *circuitBreaker) notify(from, to BreakerState) {
+	if from == to {
+		return
+	}
+	for _, fn := range b.group.onStateChange {
+		fn(b.method, from, to)
+	}
+}
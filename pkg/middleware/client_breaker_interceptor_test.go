@@ -0,0 +1,207 @@
+package middleware
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testOpenTimeout = 20 * time.Millisecond
+
+type breakerStep struct {
+	//调用前等待的时间
+	wait time.Duration
+	//invoker返回的状态码
+	code codes.Code
+	//调用不立即返回，由之后的finish步骤按code结束
+	hold bool
+	//结束最早一个hold的调用
+	finish bool
+	//是否被熔断拒绝，没有调用invoker
+	rejected bool
+	//这一步之后的状态
+	state BreakerState
+}
+
+// 没有结束的调用
+type heldCall struct {
+	code chan codes.Code
+	done chan struct{}
+}
+
+type fakeInvoker struct {
+	mu      sync.Mutex
+	invoked bool
+	codes   chan codes.Code
+	entered chan struct{}
+}
+
+func (f *fakeInvoker) invoke(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+	f.mu.Lock()
+	f.invoked = true
+	f.mu.Unlock()
+
+	if f.entered != nil {
+		close(f.entered)
+	}
+	return status.Error(<-f.codes, "fake")
+}
+
+func TestUnaryClientBreaker(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []BreakerOption
+		steps []breakerStep
+	}{
+		{
+			name: "closed to open",
+			opts: []BreakerOption{WithFailureThreshold(2)},
+			steps: []breakerStep{
+				{code: codes.Unavailable, state: StateClosed},
+				{code: codes.Unavailable, state: StateOpen},
+				{code: codes.OK, rejected: true, state: StateOpen},
+			},
+		},
+		{
+			name: "success and non failure codes keep closed",
+			opts: []BreakerOption{WithFailureThreshold(2)},
+			steps: []breakerStep{
+				{code: codes.Unavailable, state: StateClosed},
+				{code: codes.OK, state: StateClosed},
+				{code: codes.Unavailable, state: StateClosed},
+				{code: codes.NotFound, state: StateClosed},
+				{code: codes.InvalidArgument, state: StateClosed},
+			},
+		},
+		{
+			name: "half-open probe succeeds",
+			opts: []BreakerOption{WithFailureThreshold(1)},
+			steps: []breakerStep{
+				{code: codes.Unavailable, state: StateOpen},
+				{wait: 2 * testOpenTimeout, hold: true, state: StateHalfOpen},
+				//只允许一个探测请求
+				{code: codes.OK, rejected: true, state: StateHalfOpen},
+				{finish: true, code: codes.OK, state: StateClosed},
+				{code: codes.OK, state: StateClosed},
+			},
+		},
+		{
+			name: "half-open probe fails",
+			opts: []BreakerOption{WithFailureThreshold(1)},
+			steps: []breakerStep{
+				{code: codes.Unavailable, state: StateOpen},
+				{wait: 2 * testOpenTimeout, hold: true, state: StateHalfOpen},
+				{finish: true, code: codes.Internal, state: StateOpen},
+				{code: codes.OK, rejected: true, state: StateOpen},
+			},
+		},
+		{
+			name: "stale success ignored after open",
+			opts: []BreakerOption{WithFailureThreshold(1)},
+			steps: []breakerStep{
+				{hold: true, state: StateClosed},
+				{code: codes.Unavailable, state: StateOpen},
+				//熔断前放行的请求成功，不能让熔断器恢复
+				{finish: true, code: codes.OK, state: StateOpen},
+				{code: codes.OK, rejected: true, state: StateOpen},
+			},
+		},
+		{
+			name: "stale failure ignored after close",
+			opts: []BreakerOption{WithFailureThreshold(1)},
+			steps: []breakerStep{
+				{code: codes.Unavailable, state: StateOpen},
+				{wait: 2 * testOpenTimeout, hold: true, state: StateHalfOpen},
+				{finish: true, code: codes.OK, state: StateClosed},
+				{hold: true, state: StateClosed},
+				{code: codes.Unavailable, state: StateOpen},
+				{wait: 2 * testOpenTimeout, code: codes.OK, state: StateClosed},
+				//上一轮closed放行的请求失败，不计入新一轮
+				{finish: true, code: codes.Unavailable, state: StateClosed},
+			},
+		},
+		{
+			name: "cancelled probe released",
+			opts: []BreakerOption{WithFailureThreshold(1)},
+			steps: []breakerStep{
+				{code: codes.Unavailable, state: StateOpen},
+				{wait: 2 * testOpenTimeout, hold: true, state: StateHalfOpen},
+				{finish: true, code: codes.Canceled, state: StateHalfOpen},
+				//取消的探测释放名额，可以重新探测
+				{code: codes.OK, state: StateClosed},
+			},
+		},
+		{
+			name: "stuck probe times out",
+			opts: []BreakerOption{WithFailureThreshold(1), WithProbeTimeout(2 * testOpenTimeout)},
+			steps: []breakerStep{
+				{code: codes.Unavailable, state: StateOpen},
+				{wait: 2 * testOpenTimeout, hold: true, state: StateHalfOpen},
+				{code: codes.OK, rejected: true, state: StateHalfOpen},
+				{wait: 3 * testOpenTimeout, code: codes.OK, state: StateClosed},
+				//超时的探测结果属于上一轮，不再统计
+				{finish: true, code: codes.Unavailable, state: StateClosed},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			state := StateClosed
+			opts := append([]BreakerOption{
+				WithOpenTimeout(testOpenTimeout),
+				WithStateChange(func(method string, from, to BreakerState) {
+					mu.Lock()
+					state = to
+					mu.Unlock()
+				}),
+			}, tt.opts...)
+			breaker := UnaryClientBreaker(opts...)
+
+			var held []heldCall
+			for i, step := range tt.steps {
+				time.Sleep(step.wait)
+
+				switch {
+				case step.finish:
+					call := held[0]
+					held = held[1:]
+					call.code <- step.code
+					<-call.done
+				case step.hold:
+					inv := &fakeInvoker{codes: make(chan codes.Code, 1), entered: make(chan struct{})}
+					call := heldCall{code: inv.codes, done: make(chan struct{})}
+					go func() {
+						defer close(call.done)
+						breaker(context.Background(), "/test/Method", nil, nil, nil, inv.invoke)
+					}()
+					select {
+					case <-inv.entered:
+					case <-call.done:
+						t.Fatalf("step %d: held call rejected", i)
+					}
+					held = append(held, call)
+				default:
+					inv := &fakeInvoker{codes: make(chan codes.Code, 1)}
+					inv.codes <- step.code
+					breaker(context.Background(), "/test/Method", nil, nil, nil, inv.invoke)
+					if rejected := !inv.invoked; rejected != step.rejected {
+						t.Fatalf("step %d: rejected %v, want %v", i, rejected, step.rejected)
+					}
+				}
+
+				mu.Lock()
+				got := state
+				mu.Unlock()
+				if got != step.state {
+					t.Fatalf("step %d: state %s, want %s", i, got, step.state)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"context"
+	"github.com/lackone/grpc-study/pkg/errcode"
+	"google.golang.org/grpc"
+	"io"
+)
+
+// 把服务端返回的错误转换成*errcode.Error，业务代码可以直接判断错误码
+func UnaryClientError() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, resp interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return toClientError(invoker(ctx, method, req, resp, cc, opts...))
+	}
+}
+
+func StreamClientError() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, toClientError(err)
+		}
+		return &errorClientStream{ClientStream: stream}, nil
+	}
+}
+
+type errorClientStream struct {
+	grpc.ClientStream
+}
+
+func (s *errorClientStream) SendMsg(m interface{}) error {
+	return toClientError(s.ClientStream.SendMsg(m))
+}
+
+func (s *errorClientStream) RecvMsg(m interface{}) error {
+	return toClientError(s.ClientStream.RecvMsg(m))
+}
+
+func (s *errorClientStream) CloseSend() error {
+	return toClientError(s.ClientStream.CloseSend())
+}
+
+func toClientError(err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	return errcode.FromRPCError(err)
+}
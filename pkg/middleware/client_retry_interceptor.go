@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"context"
+	grpc_retry "github.com/grpc-ecosystem/go-grpc-middleware/retry"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"sync"
+	"time"
+)
+
+type retryDelayKey struct{}
+
+// 最近一次失败的请求中服务端建议的重试间隔
+type retryDelay struct {
+	mu    sync.Mutex
+	delay time.Duration
+}
+
+// 包装grpc_retry，服务端限流、降载时返回的RetryInfo作为最小的退避时间，
+// 避免令牌桶还没有恢复就重试，backoff为没有RetryInfo时的退避时间
+func UnaryClientRetry(backoff grpc_retry.BackoffFunc, opts ...grpc_retry.CallOption) grpc.UnaryClientInterceptor {
+	opts = append(opts, grpc_retry.WithBackoffContext(func(ctx context.Context, attempt uint) time.Duration {
+		d := backoff(attempt)
+		if rd, ok := ctx.Value(retryDelayKey{}).(*retryDelay); ok {
+			rd.mu.Lock()
+			if rd.delay > d {
+				d = rd.delay
+			}
+			rd.mu.Unlock()
+		}
+		return d
+	}))
+	retry := grpc_retry.UnaryClientInterceptor(opts...)
+
+	return func(ctx context.Context, method string, req, resp interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		rd := &retryDelay{}
+		ctx = context.WithValue(ctx, retryDelayKey{}, rd)
+
+		//每次尝试后记录RetryInfo，grpc_retry计算退避时间时读取
+		attempt := func(ctx context.Context, method string, req, resp interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			err := invoker(ctx, method, req, resp, cc, opts...)
+
+			rd.mu.Lock()
+			rd.delay = retryInfoDelay(err)
+			rd.mu.Unlock()
+
+			return err
+		}
+		return retry(ctx, method, req, resp, cc, attempt, callOpts...)
+	}
+}
+
+func retryInfoDelay(err error) time.Duration {
+	if err == nil {
+		return 0
+	}
+	for _, detail := range status.Convert(err).Details() {
+		if ri, ok := detail.(*errdetails.RetryInfo); ok && ri.RetryDelay != nil {
+			return ri.RetryDelay.AsDuration()
+		}
+	}
+	return 0
+}
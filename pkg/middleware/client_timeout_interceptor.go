@@ -3,22 +3,80 @@ package middleware
 import (
 	"context"
 	"google.golang.org/grpc"
+	"sync"
 	"time"
 )
 
-func defaultContextTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
-	var cancel context.CancelFunc
-	if _, ok := ctx.Deadline(); !ok {
-		defaultTimeout := 60 * time.Second
-		ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+type TimeoutOption func(*clientTimeout)
+
+type clientTimeout struct {
+	defaultTimeout time.Duration
+	methods        map[string]time.Duration
+}
+
+// 没有单独配置的方法使用的超时时间，默认60秒
+func WithDefaultTimeout(d time.Duration) TimeoutOption {
+	return func(c *clientTimeout) {
+		c.defaultTimeout = d
+	}
+}
+
+// 按完整方法名配置超时时间，如 /proto.ArticleService/GetArticleList
+func WithMethodTimeouts(timeouts map[string]time.Duration) TimeoutOption {
+	return func(c *clientTimeout) {
+		for method, d := range timeouts {
+			c.methods[method] = d
+		}
+	}
+}
+
+func newClientTimeout(opts ...TimeoutOption) *clientTimeout {
+	c := &clientTimeout{
+		defaultTimeout: 60 * time.Second,
+		methods:        map[string]time.Duration{},
+	}
+	for _, fn := range opts {
+		fn(c)
+	}
+	return c
+}
+
+// 单次调用的超时时间，优先于拦截器的配置
+type CallTimeout struct {
+	grpc.EmptyCallOption
+	Timeout time.Duration
+}
+
+func WithCallTimeout(d time.Duration) grpc.CallOption {
+	return CallTimeout{Timeout: d}
+}
+
+// ctx没有截止时间时，按方法设置超时
+func (c *clientTimeout) context(ctx context.Context, method string, opts []grpc.CallOption) (context.Context, context.CancelFunc) {
+	timeout, ok := c.methods[method]
+	if !ok {
+		timeout = c.defaultTimeout
+	}
+
+	override := false
+	for _, opt := range opts {
+		if o, ok := opt.(CallTimeout); ok {
+			timeout = o.Timeout
+			override = true
+		}
 	}
 
-	return ctx, cancel
+	if _, ok := ctx.Deadline(); (ok && !override) || timeout <= 0 {
+		return ctx, nil
+	}
+	return context.WithTimeout(ctx, timeout)
 }
 
-func UnaryContextTimeout() grpc.UnaryClientInterceptor {
+func UnaryContextTimeout(opts ...TimeoutOption) grpc.UnaryClientInterceptor {
+	c := newClientTimeout(opts...)
+
 	return func(ctx context.Context, method string, req, resp interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
-		ctx, cancel := defaultContextTimeout(ctx)
+		ctx, cancel := c.context(ctx, method, opts)
 		if cancel != nil {
 			defer cancel()
 		}
@@ -27,13 +85,37 @@ func UnaryContextTimeout() grpc.UnaryClientInterceptor {
 	}
 }
 
-func StreamContextTimeout() grpc.StreamClientInterceptor {
+// 超时的context在流结束时才取消，不能在创建流后立即取消
+func StreamContextTimeout(opts ...TimeoutOption) grpc.StreamClientInterceptor {
+	c := newClientTimeout(opts...)
+
 	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
-		ctx, cancel := defaultContextTimeout(ctx)
-		if cancel != nil {
-			defer cancel()
+		ctx, cancel := c.context(ctx, method, opts)
+		if cancel == nil {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			cancel()
+			return nil, err
 		}
+		return &timeoutClientStream{ClientStream: stream, cancel: cancel, serverStreams: desc.ServerStreams}, nil
+	}
+}
+
+type timeoutClientStream struct {
+	grpc.ClientStream
+	cancel        context.CancelFunc
+	serverStreams bool
+	once          sync.Once
+}
 
-		return streamer(ctx, desc, cc, method, opts...)
+// 收到EOF或者错误说明流已经结束，服务端非流式时收到响应后也结束
+func (s *timeoutClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil || !s.serverStreams {
+		s.once.Do(s.cancel)
 	}
+	return err
 }
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"github.com/lackone/grpc-study/pkg/errcode"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+type DeadlineOption func(*serverDeadline)
+
+type serverDeadline struct {
+	defaultDeadline time.Duration
+	maxDeadline     time.Duration
+	methods         map[string]methodDeadline
+}
+
+type methodDeadline struct {
+	defaultDeadline time.Duration
+	maxDeadline     time.Duration
+}
+
+// 客户端没有设置截止时间时使用的超时时间，默认30秒
+func WithDefaultDeadline(d time.Duration) DeadlineOption {
+	return func(s *serverDeadline) {
+		s.defaultDeadline = d
+	}
+}
+
+// 允许的最大执行时间，客户端设置的截止时间超过时会被缩短，默认60秒
+func WithMaxDeadline(d time.Duration) DeadlineOption {
+	return func(s *serverDeadline) {
+		s.maxDeadline = d
+	}
+}
+
+// 单独设置某个方法的默认超时和最大执行时间，0表示使用全局配置
+func WithMethodDeadline(method string, defaultDeadline, maxDeadline time.Duration) DeadlineOption {
+	return func(s *serverDeadline) {
+		s.methods[method] = methodDeadline{defaultDeadline: defaultDeadline, maxDeadline: maxDeadline}
+	}
+}
+
+func newServerDeadline(opts ...DeadlineOption) *serverDeadline {
+	s := &serverDeadline{
+		defaultDeadline: 30 * time.Second,
+		maxDeadline:     60 * time.Second,
+		methods:         map[string]methodDeadline{},
+	}
+	for _, fn := range opts {
+		fn(s)
+	}
+	return s
+}
+
+// 给请求设置截止时间，handler中使用ctx访问数据库(db.DB.WithContext(ctx))和调用下游grpc服务时，剩余时间会随ctx传递
+func Deadline(opts ...DeadlineOption) grpc.UnaryServerInterceptor {
+	s := newServerDeadline(opts...)
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, cancel := s.context(ctx, info.FullMethod)
+		defer cancel()
+
+		resp, err := handler(ctx, req)
+		return resp, deadlineError(ctx, err)
+	}
+}
+
+// 流的截止时间从建立开始计算，长连接的流需要单独配置较大的最大执行时间
+func StreamDeadline(opts ...DeadlineOption) grpc.StreamServerInterceptor {
+	s := newServerDeadline(opts...)
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, cancel := s.context(ss.Context(), info.FullMethod)
+		defer cancel()
+
+		err := handler(srv, &contextServerStream{ServerStream: ss, ctx: ctx})
+		return deadlineError(ctx, err)
+	}
+}
+
+func (s *serverDeadline) context(ctx context.Context, method string) (context.Context, context.CancelFunc) {
+	defaultDeadline, maxDeadline := s.defaultDeadline, s.maxDeadline
+	if m, ok := s.methods[method]; ok {
+		if m.defaultDeadline > 0 {
+			defaultDeadline = m.defaultDeadline
+		}
+		if m.maxDeadline > 0 {
+			maxDeadline = m.maxDeadline
+		}
+	}
+
+	timeout := defaultDeadline
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	if maxDeadline > 0 && timeout > maxDeadline {
+		timeout = maxDeadline
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// 截止时间到了之后，handler返回的context错误或其他错误统一转换成errcode.DeadlineExceeded
+func deadlineError(ctx context.Context, err error) error {
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	if err == nil || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) ||
+		status.Code(err) == codes.DeadlineExceeded || status.Code(err) == codes.Unknown {
+		return errcode.DeadlineExceeded
+	}
+	return err
+}
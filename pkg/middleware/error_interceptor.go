@@ -2,17 +2,43 @@ package middleware
 
 import (
 	"context"
-	"fmt"
 	"github.com/lackone/grpc-study/pkg/errcode"
+	"github.com/lackone/grpc-study/pkg/logger"
+	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"time"
 )
 
 func Error(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 	resp, err := handler(ctx, req)
 	if err != nil {
-		errLog := "error log: method: %s, code: %v, message: %v, details: %v\n"
-		s := errcode.FromError(err)
-		fmt.Printf(errLog, info.FullMethod, s.Code(), s.Err().Error(), s.Details())
+		logger.Ctx(ctx).Log(logLevel(err), "error log", errorFields(err)...)
 	}
 	return resp, err
 }
+
+func StreamError(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	stream := newCountingServerStream(ss)
+
+	err := handler(srv, stream)
+	if err != nil {
+		fields := append(errorFields(err),
+			zap.Int64("messages_received", stream.received.Load()),
+			zap.Int64("messages_sent", stream.sent.Load()),
+			zap.Duration("duration", time.Since(stream.beginTime)),
+		)
+		logger.Ctx(stream.Context()).Log(logLevel(err), "error log", fields...)
+	}
+	return err
+}
+
+func errorFields(err error) []zap.Field {
+	s, _ := status.FromError(err)
+	return []zap.Field{
+		zap.String("code", s.Code().String()),
+		zap.Int("errcode", errcode.FromRPCError(err).Code()),
+		zap.String("error", s.Message()),
+		zap.Any("details", s.Details()),
+	}
+}
@@ -0,0 +1,191 @@
+package middleware
+
+import (
+	"context"
+	"github.com/lackone/grpc-study/pkg/errcode"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"math"
+	"sync"
+	"time"
+)
+
+type LoadShedOption func(*loadShedder)
+
+type loadShedder struct {
+	maxInFlight    int
+	methodInFlight map[string]int
+	maxQueueTime   time.Duration
+	targetLatency  time.Duration
+	mu             sync.Mutex
+	methods        map[string]*methodLimiter
+}
+
+// 每个方法默认的最大并发数
+func WithMaxInFlight(n int) LoadShedOption {
+	return func(l *loadShedder) {
+		l.maxInFlight = n
+	}
+}
+
+// 单独设置某个方法的最大并发数
+func WithMethodMaxInFlight(method string, n int) LoadShedOption {
+	return func(l *loadShedder) {
+		l.methodInFlight[method] = n
+	}
+}
+
+// 达到最大并发后排队等待的最长时间，超时返回ResourceExhausted
+func WithMaxQueueTime(d time.Duration) LoadShedOption {
+	return func(l *loadShedder) {
+		l.maxQueueTime = d
+	}
+}
+
+// 目标延迟(排队+处理)，平均延迟超过目标时降低并发上限，超出上限的请求返回Unavailable
+func WithTargetLatency(d time.Duration) LoadShedOption {
+	return func(l *loadShedder) {
+		l.targetLatency = d
+	}
+}
+
+func newLoadShedder(opts ...LoadShedOption) *loadShedder {
+	l := &loadShedder{
+		maxInFlight:    100,
+		methodInFlight: map[string]int{},
+		maxQueueTime:   100 * time.Millisecond,
+		methods:        map[string]*methodLimiter{},
+	}
+	for _, fn := range opts {
+		fn(l)
+	}
+	return l
+}
+
+// 限制每个方法的并发数，并根据延迟自适应丢弃请求，客户端可以通过grpc_retry退避重试
+func LoadShed(opts ...LoadShedOption) grpc.UnaryServerInterceptor {
+	l := newLoadShedder(opts...)
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		m := l.method(info.FullMethod)
+
+		beginTime := time.Now()
+		if err := m.acquire(ctx, l.maxQueueTime); err != nil {
+			return nil, err
+		}
+		defer func() {
+			m.release(time.Since(beginTime), l.targetLatency)
+		}()
+
+		return handler(ctx, req)
+	}
+}
+
+func StreamLoadShed(opts ...LoadShedOption) grpc.StreamServerInterceptor {
+	l := newLoadShedder(opts...)
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		m := l.method(info.FullMethod)
+
+		if err := m.acquire(ss.Context(), l.maxQueueTime); err != nil {
+			return err
+		}
+		//流的持续时间和请求量无关，不参与延迟统计
+		defer m.release(0, 0)
+
+		return handler(srv, ss)
+	}
+}
+
+func (l *loadShedder) method(method string) *methodLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if m, ok := l.methods[method]; ok {
+		return m
+	}
+
+	max := l.maxInFlight
+	if n, ok := l.methodInFlight[method]; ok {
+		max = n
+	}
+	m := &methodLimiter{
+		slots: make(chan struct{}, max),
+		max:   float64(max),
+		limit: float64(max),
+	}
+	l.methods[method] = m
+	return m
+}
+
+type methodLimiter struct {
+	//已占用的个数就是正在处理的请求数
+	slots chan struct{}
+
+	mu  sync.Mutex
+	max float64
+	//自适应的并发上限
+	limit float64
+	//延迟的指数加权平均
+	latency time.Duration
+	//上次降低上限的时间，一个目标延迟内只降低一次
+	decreased time.Time
+}
+
+func (m *methodLimiter) acquire(ctx context.Context, maxQueueTime time.Duration) error {
+	m.mu.Lock()
+	limit := m.limit
+	m.mu.Unlock()
+
+	//延迟升高导致上限降低时，直接丢弃超出上限的请求
+	if limit < m.max && len(m.slots) >= int(math.Ceil(limit)) {
+		return errcode.Unavailable
+	}
+
+	select {
+	case m.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(maxQueueTime)
+	defer timer.Stop()
+
+	select {
+	case m.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return errcode.LimitExceed
+	case <-ctx.Done():
+		//客户端取消或超时，不能返回ResourceExhausted，否则客户端会重试
+		return deadlineError(ctx, status.FromContextError(ctx.Err()).Err())
+	}
+}
+
+// AIMD：延迟超过目标时上限乘以0.9，一个目标延迟的时间窗口内只降低一次，
+// 否则短时间的突发请求会把上限一直降到1；延迟正常时加1
+func (m *methodLimiter) release(latency, target time.Duration) {
+	<-m.slots
+
+	if target <= 0 || latency <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.latency == 0 {
+		m.latency = latency
+	} else {
+		m.latency = (m.latency*9 + latency) / 10
+	}
+
+	if m.latency > target {
+		if now := time.Now(); now.Sub(m.decreased) >= target {
+			m.limit = math.Max(1, m.limit*0.9)
+			m.decreased = now
+		}
+	} else {
+		m.limit = math.Min(m.max, m.limit+1)
+	}
+}
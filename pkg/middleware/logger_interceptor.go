@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"context"
+	"github.com/lackone/grpc-study/pkg/logger"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// 把请求级别的logger放到ctx中，放在otelgrpc之后、其他记录日志的拦截器之前，
+// 之后通过logger.Ctx(ctx)记录的日志都会带上trace_id、span_id、request_id、method和调用方
+func Logger(l *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(logger.NewContext(ctx, l), req)
+	}
+}
+
+func StreamLogger(l *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := logger.NewContext(ss.Context(), l)
+		return handler(srv, &contextServerStream{ServerStream: ss, ctx: ctx})
+	}
+}
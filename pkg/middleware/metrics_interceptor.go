@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"context"
+	"github.com/lackone/grpc-study/pkg/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// 统计请求数、延迟和正在处理的请求数，放在拦截器链的最前面，被拦截器拒绝的请求也会统计
+func Metrics(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	const grpcType = "unary"
+
+	inFlight := metrics.GrpcServerInFlight.WithLabelValues(grpcType, info.FullMethod)
+	inFlight.Inc()
+	defer inFlight.Dec()
+
+	beginTime := time.Now()
+	resp, err := handler(ctx, req)
+
+	code := status.Code(err).String()
+	metrics.GrpcServerHandled.WithLabelValues(grpcType, info.FullMethod, code).Inc()
+	metrics.GrpcServerHandlingSeconds.WithLabelValues(grpcType, info.FullMethod, code).Observe(time.Since(beginTime).Seconds())
+
+	return resp, err
+}
+
+func StreamMetrics(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	grpcType := streamType(info)
+
+	inFlight := metrics.GrpcServerInFlight.WithLabelValues(grpcType, info.FullMethod)
+	inFlight.Inc()
+	defer inFlight.Dec()
+
+	stream := newCountingServerStream(ss)
+	err := handler(srv, stream)
+
+	code := status.Code(err).String()
+	metrics.GrpcServerHandled.WithLabelValues(grpcType, info.FullMethod, code).Inc()
+	metrics.GrpcServerHandlingSeconds.WithLabelValues(grpcType, info.FullMethod, code).Observe(time.Since(stream.beginTime).Seconds())
+	metrics.GrpcServerMsgReceived.WithLabelValues(grpcType, info.FullMethod).Add(float64(stream.received.Load()))
+	metrics.GrpcServerMsgSent.WithLabelValues(grpcType, info.FullMethod).Add(float64(stream.sent.Load()))
+
+	return err
+}
+
+func streamType(info *grpc.StreamServerInfo) string {
+	switch {
+	case info.IsClientStream && info.IsServerStream:
+		return "bidi_stream"
+	case info.IsClientStream:
+		return "client_stream"
+	case info.IsServerStream:
+		return "server_stream"
+	}
+	return "unary"
+}
@@ -0,0 +1,237 @@
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/lackone/grpc-study/pkg/auth"
+	"github.com/lackone/grpc-study/pkg/errcode"
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rateLimitLimitKey     = "x-ratelimit-limit"
+	rateLimitRemainingKey = "x-ratelimit-remaining"
+	rateLimitResetKey     = "x-ratelimit-reset"
+)
+
+// 限流的维度
+type RateLimitKey int
+
+const (
+	LimitByMethod RateLimitKey = iota
+	LimitByAppKey
+	LimitByPeer
+)
+
+// 限流规则，Method为完整方法名，* 表示没有单独配置的方法，按方法和调用方分别限流
+type RateLimitRule struct {
+	Method string
+	Key    RateLimitKey
+	//每秒产生的令牌数
+	Rate float64
+	//令牌桶容量
+	Burst int
+	//LimitByPeer时，X-Forwarded-For末尾可信代理的个数，grpc-gateway自己追加的地址不算，
+	//如gateway前面有一层nginx时为1，客户端自己传的X-Forwarded-For不会被使用
+	TrustedProxies int
+}
+
+type rateLimiter struct {
+	rules    map[string]RateLimitRule
+	mu       sync.Mutex
+	limiters map[string]*list.Element
+	//按最近使用排序，最前面的最久没有使用
+	order *list.List
+}
+
+type limiterEntry struct {
+	key      string
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// 令牌桶数量超过该值时清理空闲的令牌桶，仍然超过时淘汰最久没有使用的令牌桶
+const maxLimiters = 10000
+
+func newRateLimiter(rules ...RateLimitRule) *rateLimiter {
+	r := &rateLimiter{
+		rules:    map[string]RateLimitRule{},
+		limiters: map[string]*list.Element{},
+		order:    list.New(),
+	}
+	for _, rule := range rules {
+		r.rules[rule.Method] = rule
+	}
+	return r
+}
+
+// 令牌桶限流，超出时返回errcode.LimitExceed和RetryInfo
+func RateLimit(rules ...RateLimitRule) grpc.UnaryServerInterceptor {
+	r := newRateLimiter(rules...)
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, err := r.allow(ctx, info.FullMethod)
+		if md != nil {
+			grpc.SetHeader(ctx, md)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func StreamRateLimit(rules ...RateLimitRule) grpc.StreamServerInterceptor {
+	r := newRateLimiter(rules...)
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		md, err := r.allow(ss.Context(), info.FullMethod)
+		if md != nil {
+			ss.SetHeader(md)
+		}
+		if err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func (r *rateLimiter) allow(ctx context.Context, method string) (metadata.MD, error) {
+	rule, ok := r.rules[method]
+	if !ok {
+		rule, ok = r.rules["*"]
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	//* 规则对每个方法分别限流，不同方法不共用令牌桶
+	limiter := r.limiter(method+"|"+limitKey(ctx, rule, method), rule)
+
+	now := time.Now()
+	allowed := limiter.AllowN(now, 1)
+
+	md := metadata.Pairs(
+		rateLimitLimitKey, strconv.Itoa(rule.Burst),
+		rateLimitRemainingKey, strconv.Itoa(int(math.Max(0, limiter.TokensAt(now)))),
+	)
+
+	if allowed {
+		return md, nil
+	}
+
+	//下一个令牌产生需要等待的时间
+	delay := time.Second
+	if rule.Rate > 0 {
+		delay = time.Duration(float64(time.Second) * (1 - limiter.TokensAt(now)) / rule.Rate)
+	}
+	md.Set(rateLimitResetKey, strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+
+	return md, errcode.LimitExceed.WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(delay),
+	})
+}
+
+func (r *rateLimiter) limiter(key string, rule RateLimitRule) *rate.Limiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if el, ok := r.limiters[key]; ok {
+		e := el.Value.(*limiterEntry)
+		e.lastSeen = now
+		r.order.MoveToBack(el)
+		return e.limiter
+	}
+
+	if r.order.Len() >= maxLimiters {
+		//清理空闲的令牌桶，最前面的最久没有使用
+		for el := r.order.Front(); el != nil && now.Sub(el.Value.(*limiterEntry).lastSeen) > 10*time.Minute; el = r.order.Front() {
+			r.remove(el)
+		}
+		//仍然超过时淘汰最久没有使用的令牌桶，避免伪造的key让内存无限增长
+		for r.order.Len() >= maxLimiters {
+			r.remove(r.order.Front())
+		}
+	}
+
+	l := rate.NewLimiter(rate.Limit(rule.Rate), rule.Burst)
+	r.limiters[key] = r.order.PushBack(&limiterEntry{key: key, limiter: l, lastSeen: now})
+	return l
+}
+
+func (r *rateLimiter) remove(el *list.Element) {
+	r.order.Remove(el)
+	delete(r.limiters, el.Value.(*limiterEntry).key)
+}
+
+func limitKey(ctx context.Context, rule RateLimitRule, method string) string {
+	switch rule.Key {
+	case LimitByAppKey:
+		if id, ok := auth.FromContext(ctx); ok {
+			if id.AppKey != "" {
+				return id.AppKey
+			}
+			return id.Subject
+		}
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			return firstValue(md, auth.AppKeyKey)
+		}
+		return ""
+	case LimitByPeer:
+		return peerIP(ctx, rule.TrustedProxies)
+	}
+	return method
+}
+
+// 通过grpc-gateway调用时，使用X-Forwarded-For中的客户端地址，
+// gateway把连接的地址追加在最后，前面的地址由客户端控制，只取跳过可信代理后的那一个
+func peerIP(ctx context.Context, trustedProxies int) string {
+	var ip string
+	if p, ok := peer.FromContext(ctx); ok {
+		ip = p.Addr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+	}
+
+	if parsed := net.ParseIP(ip); parsed == nil || parsed.IsLoopback() {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if xff := strings.Join(md.Get("x-forwarded-for"), ","); xff != "" {
+				ips := strings.Split(xff, ",")
+				i := len(ips) - 1 - trustedProxies
+				if i < 0 {
+					i = 0
+				}
+				return strings.TrimSpace(ips[i])
+			}
+		}
+	}
+	return ip
+}
+
+// grpc-gateway的响应头匹配，限流相关的metadata转换成X-RateLimit-*，其他的保持默认的Grpc-Metadata-前缀
+func RateLimitHeaderMatcher(key string) (string, bool) {
+	switch strings.ToLower(key) {
+	case rateLimitLimitKey:
+		return "X-RateLimit-Limit", true
+	case rateLimitRemainingKey:
+		return "X-RateLimit-Remaining", true
+	case rateLimitResetKey:
+		return "X-RateLimit-Reset", true
+	}
+	return fmt.Sprintf("%s%s", runtime.MetadataHeaderPrefix, key), true
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"context"
+	"github.com/lackone/grpc-study/pkg/auth"
+	"github.com/lackone/grpc-study/pkg/errcode"
+	"github.com/lackone/grpc-study/pkg/logger"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+type RBACOption func(*rbac)
+
+type rbac struct {
+	policy *auth.Policy
+	dryRun bool
+	logger *zap.Logger
+}
+
+// 只记录拒绝日志，不拦截请求，用于上线新策略前观察
+func WithDryRun(dryRun bool) RBACOption {
+	return func(r *rbac) {
+		r.dryRun = dryRun
+	}
+}
+
+func WithRBACLogger(l *zap.Logger) RBACOption {
+	return func(r *rbac) {
+		r.logger = l
+	}
+}
+
+func newRBAC(policy *auth.Policy, opts ...RBACOption) *rbac {
+	r := &rbac{policy: policy}
+	for _, fn := range opts {
+		fn(r)
+	}
+	if r.logger == nil {
+		r.logger = logger.Default()
+	}
+	return r
+}
+
+// 按认证后的角色检查是否允许调用方法，需要放在认证拦截器之后
+func RBAC(policy *auth.Policy, opts ...RBACOption) grpc.UnaryServerInterceptor {
+	r := newRBAC(policy, opts...)
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := r.authorize(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func StreamRBAC(policy *auth.Policy, opts ...RBACOption) grpc.StreamServerInterceptor {
+	r := newRBAC(policy, opts...)
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := r.authorize(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func (r *rbac) authorize(ctx context.Context, method string) error {
+	roles := []string{auth.AnonymousRole}
+	if id, ok := auth.FromContext(ctx); ok {
+		roles = id.Roles
+	}
+
+	if r.policy.Allowed(roles, method) {
+		return nil
+	}
+
+	logger.WithContext(ctx, r.logger).Warn("rbac denied",
+		zap.Strings("roles", roles),
+		zap.Bool("dry_run", r.dryRun),
+	)
+
+	if r.dryRun {
+		return nil
+	}
+	return errcode.AccessDenied
+}
@@ -2,18 +2,147 @@ package middleware
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"github.com/lackone/grpc-study/pkg/errcode"
+	"github.com/lackone/grpc-study/pkg/logger"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"runtime/debug"
+	"strings"
+	"time"
 )
 
-func Recovery(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	defer func() {
-		if e := recover(); e != nil {
-			recoveryLog := "recovery log: method: %s, message: %v, stack: %s\n"
-			fmt.Printf(recoveryLog, info.FullMethod, e, string(debug.Stack()[:]))
-		}
-	}()
+// 发生panic时的信息，IncidentId会返回给客户端，方便排查
+type Panic struct {
+	IncidentId string
+	Method     string
+	Value      interface{}
+	Stack      []byte
+}
+
+// panic处理钩子，可以用来上报指标、发送告警
+type PanicHandler func(ctx context.Context, p *Panic)
+
+type RecoveryOption func(*recovery)
+
+type recovery struct {
+	handlers []PanicHandler
+	debug    bool
+}
+
+func WithPanicHandler(fn PanicHandler) RecoveryOption {
+	return func(r *recovery) {
+		r.handlers = append(r.handlers, fn)
+	}
+}
+
+// 调试模式下错误详情中会带上panic信息和堆栈
+func WithDebug(debug bool) RecoveryOption {
+	return func(r *recovery) {
+		r.debug = debug
+	}
+}
+
+func newRecovery(opts ...RecoveryOption) *recovery {
+	r := &recovery{}
+	for _, fn := range opts {
+		fn(r)
+	}
+	return r
+}
+
+func Recovery(opts ...RecoveryOption) grpc.UnaryServerInterceptor {
+	r := newRecovery(opts...)
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if e := recover(); e != nil {
+				p := r.recover(ctx, info.FullMethod, e)
+
+				logger.Ctx(ctx).Error("recovery log", panicFields(p)...)
+
+				resp, err = nil, r.error(p)
+			}
+		}()
+
+		return handler(ctx, req)
+	}
+}
+
+func StreamRecovery(opts ...RecoveryOption) grpc.StreamServerInterceptor {
+	r := newRecovery(opts...)
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		stream := newCountingServerStream(ss)
+
+		defer func() {
+			if e := recover(); e != nil {
+				p := r.recover(stream.Context(), info.FullMethod, e)
+
+				fields := append(panicFields(p),
+					zap.Int64("messages_received", stream.received.Load()),
+					zap.Int64("messages_sent", stream.sent.Load()),
+					zap.Duration("duration", time.Since(stream.beginTime)),
+				)
+				logger.Ctx(stream.Context()).Error("recovery log", fields...)
+
+				err = r.error(p)
+			}
+		}()
+
+		return handler(srv, stream)
+	}
+}
+
+func (r *recovery) recover(ctx context.Context, method string, e interface{}) *Panic {
+	p := &Panic{
+		IncidentId: newIncidentId(),
+		Method:     method,
+		Value:      e,
+		Stack:      debug.Stack(),
+	}
+
+	for _, fn := range r.handlers {
+		fn(ctx, p)
+	}
+
+	return p
+}
+
+// 转换成errcode.Fail返回，客户端收到codes.Internal
+func (r *recovery) error(p *Panic) error {
+	details := []interface{}{
+		&errdetails.ErrorInfo{
+			Reason:   "PANIC",
+			Metadata: map[string]string{"incident_id": p.IncidentId},
+		},
+	}
+
+	if r.debug {
+		details = append(details, &errdetails.DebugInfo{
+			StackEntries: strings.Split(strings.TrimSpace(string(p.Stack)), "\n"),
+			Detail:       fmt.Sprint(p.Value),
+		})
+	}
+
+	return errcode.Fail.WithDetails(details...)
+}
+
+func panicFields(p *Panic) []zap.Field {
+	return []zap.Field{
+		zap.String("incident_id", p.IncidentId),
+		zap.String("panic", fmt.Sprint(p.Value)),
+		zap.ByteString("stack", p.Stack),
+	}
+}
 
-	return handler(ctx, req)
+func newIncidentId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
 }
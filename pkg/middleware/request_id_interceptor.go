@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"github.com/lackone/grpc-study/pkg/errcode"
+	"github.com/lackone/grpc-study/pkg/requestid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+// 接收或生成x-request-id，通过响应头和trailer返回，出错时在错误详情中带上RequestInfo，
+// 放在middleware.Logger之前，之后的日志都会带上request_id
+func RequestId(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, id := requestIdContext(ctx)
+	grpc.SetHeader(ctx, metadata.Pairs(requestid.Key, id))
+	grpc.SetTrailer(ctx, metadata.Pairs(requestid.Key, id))
+
+	resp, err := handler(ctx, req)
+	return resp, requestIdError(err, id)
+}
+
+func StreamRequestId(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, id := requestIdContext(ss.Context())
+	ss.SetHeader(metadata.Pairs(requestid.Key, id))
+	//流出错时header可能已经发送，trailer中也带上请求id
+	ss.SetTrailer(metadata.Pairs(requestid.Key, id))
+
+	err := handler(srv, &contextServerStream{ServerStream: ss, ctx: ctx})
+	return requestIdError(err, id)
+}
+
+// 调用下游grpc服务时转发请求id，grpc-gateway的连接也需要使用
+func UnaryClientRequestId() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, resp interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(outgoingRequestId(ctx), method, req, resp, cc, opts...)
+	}
+}
+
+func StreamClientRequestId() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoingRequestId(ctx), desc, cc, method, opts...)
+	}
+}
+
+// grpc-gateway的响应头匹配，X-Request-Id已经由requestid.Handler写入，不再重复添加
+func GatewayHeaderMatcher(key string) (string, bool) {
+	if strings.ToLower(key) == requestid.Key {
+		return "", false
+	}
+	return RateLimitHeaderMatcher(key)
+}
+
+func requestIdContext(ctx context.Context) (context.Context, string) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		md = metadata.MD{}
+	}
+
+	id := firstValue(md, requestid.Key)
+	if !requestid.Valid(id) {
+		id = requestid.New()
+		//替换incoming metadata中不合法的请求id
+		md = md.Copy()
+		md.Set(requestid.Key, id)
+		ctx = metadata.NewIncomingContext(ctx, md)
+	}
+	return requestid.NewContext(ctx, id), id
+}
+
+// 保留原来的状态码和错误信息，只追加RequestInfo
+func requestIdError(err error, id string) error {
+	if err == nil {
+		return nil
+	}
+
+	info := &errdetails.RequestInfo{RequestId: id}
+
+	var e *errcode.Error
+	if errors.As(err, &e) {
+		return e.WithDetails(info)
+	}
+
+	st := status.Convert(err)
+	ds, derr := st.WithDetails(info)
+	if derr != nil {
+		return err
+	}
+	return ds.Err()
+}
+
+func outgoingRequestId(ctx context.Context) context.Context {
+	id := requestid.FromContext(ctx)
+	if id == "" {
+		return ctx
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(requestid.Key)) > 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, requestid.Key, id)
+}
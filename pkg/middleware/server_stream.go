@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"google.golang.org/grpc"
+	"sync/atomic"
+	"time"
+)
+
+// 统计流上收发的消息个数、字节数和持续时间
+type countingServerStream struct {
+	grpc.ServerStream
+	beginTime     time.Time
+	sent          atomic.Int64
+	received      atomic.Int64
+	sentBytes     atomic.Int64
+	receivedBytes atomic.Int64
+}
+
+func newCountingServerStream(ss grpc.ServerStream) *countingServerStream {
+	return &countingServerStream{ServerStream: ss, beginTime: time.Now()}
+}
+
+func (s *countingServerStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.sent.Add(1)
+		s.sentBytes.Add(int64(messageSize(m)))
+	}
+	return err
+}
+
+func (s *countingServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.received.Add(1)
+		s.receivedBytes.Add(int64(messageSize(m)))
+	}
+	return err
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"context"
+	"github.com/lackone/grpc-study/pkg/validate"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// 按proto字段上声明的规则校验请求参数
+func Validate(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if m, ok := req.(proto.Message); ok {
+		if err := validate.Validate(m); err != nil {
+			return nil, err
+		}
+	}
+
+	return handler(ctx, req)
+}
+
+func StreamValidate(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &validateServerStream{ServerStream: ss})
+}
+
+type validateServerStream struct {
+	grpc.ServerStream
+}
+
+func (s *validateServerStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if msg, ok := m.(proto.Message); ok {
+		return validate.Validate(msg)
+	}
+	return nil
+}
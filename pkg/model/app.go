@@ -0,0 +1,11 @@
+package model
+
+type App struct {
+	ID        int    `json:"id" gorm:"type:int(11);primaryKey;auto_increment;comment:ID"`
+	AppKey    string `json:"app_key" gorm:"type:varchar(64);not null;default:'';uniqueIndex;comment:应用key"`
+	AppSecret string `json:"app_secret" gorm:"type:varchar(128);not null;default:'';comment:应用密钥"`
+	Name      string `json:"name" gorm:"type:varchar(32);not null;default:'';comment:应用名称"`
+	Roles     string `json:"roles" gorm:"type:varchar(255);not null;default:'';comment:角色，多个用逗号分隔"`
+	Created   uint32 `json:"created" gorm:"type:int(11);not null;autoCreateTime;comment:创建时间"`
+	Updated   uint32 `json:"updated" gorm:"type:int(11);not null;autoUpdateTime;comment:更新时间"`
+}
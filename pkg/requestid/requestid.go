@@ -0,0 +1,68 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const (
+	//grpc metadata的key
+	Key = "x-request-id"
+	//HTTP头
+	Header = "X-Request-Id"
+)
+
+// 客户端传入的请求id只允许字母、数字和-_.，最长128个字符，不符合时重新生成
+var validId = regexp.MustCompile(`^[A-Za-z0-9\-_.]{1,128}$`)
+
+type requestIdKey struct{}
+
+func New() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+func Valid(id string) bool {
+	return validId.MatchString(id)
+}
+
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, id)
+}
+
+// 优先读取ctx中的请求id，没有时读取incoming metadata中的x-request-id
+func FromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIdKey{}).(string); ok {
+		return id
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vs := md.Get(Key); len(vs) > 0 {
+			return vs[0]
+		}
+	}
+	return ""
+}
+
+// HTTP中间件，接收或生成X-Request-Id，写入响应头，
+// grpc-gateway调用grpc服务时由middleware.UnaryClientRequestId通过metadata转发
+func Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !Valid(id) {
+			id = New()
+			r.Header.Set(Header, id)
+		}
+		w.Header().Set(Header, id)
+
+		h.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	})
+}
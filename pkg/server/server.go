@@ -0,0 +1,420 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/lackone/grpc-study/pkg/errcode"
+	"github.com/soheilhy/cmux"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/reflection"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// grpc和http共用一个端口时的分流方式
+type Mux int
+
+const (
+	//cmux按连接分流，grpc连接直接交给grpc.Server
+	MuxCmux Mux = iota
+	//h2c按请求分流，grpc请求通过grpc.Server.ServeHTTP处理
+	MuxH2C
+)
+
+// 和pb.RegisterXXXHandlerFromEndpoint的签名一致
+type GatewayRegisterFunc func(ctx context.Context, mux *runtime.ServeMux, endpoint string, opts []grpc.DialOption) error
+
+type Option func(*Server)
+
+// 监听地址，如 127.0.0.1:8080、:8080
+func WithEndpoint(endpoint string) Option {
+	return func(s *Server) {
+		s.endpoint = endpoint
+	}
+}
+
+// 默认MuxCmux
+func WithMux(mux Mux) Option {
+	return func(s *Server) {
+		s.mux = mux
+	}
+}
+
+// 按顺序添加的服务端拦截器
+func WithUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) Option {
+	return func(s *Server) {
+		s.unary = append(s.unary, interceptors...)
+	}
+}
+
+func WithStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) Option {
+	return func(s *Server) {
+		s.stream = append(s.stream, interceptors...)
+	}
+}
+
+// 其他grpc.ServerOption，如消息大小限制、keepalive
+func WithServerOptions(opts ...grpc.ServerOption) Option {
+	return func(s *Server) {
+		s.serverOpts = append(s.serverOpts, opts...)
+	}
+}
+
+// 注册grpc服务
+func WithService(fn func(*grpc.Server)) Option {
+	return func(s *Server) {
+		s.services = append(s.services, fn)
+	}
+}
+
+// 注册grpc反射服务，可以使用grpcurl调试
+func WithReflection() Option {
+	return func(s *Server) {
+		s.reflection = true
+	}
+}
+
+// 注册grpc-gateway的handler，gateway通过endpoint回环调用grpc服务
+func WithGateway(fn GatewayRegisterFunc) Option {
+	return func(s *Server) {
+		s.gateways = append(s.gateways, fn)
+	}
+}
+
+// gateway的配置，默认使用errcode的错误处理，后添加的覆盖默认配置
+func WithGatewayOptions(opts ...runtime.ServeMuxOption) Option {
+	return func(s *Server) {
+		s.gatewayOpts = append(s.gatewayOpts, opts...)
+	}
+}
+
+// gateway连接grpc服务的配置，如客户端拦截器，默认不使用TLS
+func WithGatewayDialOptions(opts ...grpc.DialOption) Option {
+	return func(s *Server) {
+		s.dialOpts = append(s.dialOpts, opts...)
+	}
+}
+
+// 包装gateway的http.Handler，如otelhttp、请求id、指标，先添加的在外层
+func WithGatewayMiddleware(middlewares ...func(http.Handler) http.Handler) Option {
+	return func(s *Server) {
+		s.gatewayMiddlewares = append(s.gatewayMiddlewares, middlewares...)
+	}
+}
+
+// 注册其他http接口，如swagger、/metrics，gateway挂载在 /
+func WithHttp(fn func(*http.ServeMux)) Option {
+	return func(s *Server) {
+		s.https = append(s.https, fn)
+	}
+}
+
+type Server struct {
+	endpoint           string
+	mux                Mux
+	unary              []grpc.UnaryServerInterceptor
+	stream             []grpc.StreamServerInterceptor
+	serverOpts         []grpc.ServerOption
+	services           []func(*grpc.Server)
+	reflection         bool
+	gateways           []GatewayRegisterFunc
+	gatewayOpts        []runtime.ServeMuxOption
+	dialOpts           []grpc.DialOption
+	gatewayMiddlewares []func(http.Handler) http.Handler
+	https              []func(*http.ServeMux)
+	tracerProvider     trace.TracerProvider
+	meterProvider      metric.MeterProvider
+
+	listen     net.Listener
+	grpcServer *grpc.Server
+	httpServer *http.Server
+	h2Server   *http2.Server
+	cMux       cmux.CMux
+	cancel     context.CancelFunc
+	stopOnce   sync.Once
+
+	//h2c连接被劫持后http.Server不再等待其中的请求，自己记录处理中的请求
+	mu       sync.Mutex
+	closing  bool
+	inflight sync.WaitGroup
+}
+
+// 创建grpc服务、gateway和http服务并监听端口，调用Start开始处理请求
+func NewServer(opts ...Option) (*Server, error) {
+	s := &Server{}
+	for _, fn := range opts {
+		fn(s)
+	}
+
+	listen, err := net.Listen("tcp", s.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	s.listen = listen
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	s.grpcServer = s.newGrpcServer()
+
+	handler, err := s.newHttpHandler(ctx)
+	if err != nil {
+		s.Stop()
+		return nil, err
+	}
+	s.httpServer = &http.Server{Handler: handler}
+
+	if s.mux == MuxH2C {
+		//http.Server.Shutdown时向h2c连接发送GOAWAY，客户端不再在这些连接上发起新请求
+		if err := http2.ConfigureServer(s.httpServer, s.h2Server); err != nil {
+			s.Stop()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// 实际监听的地址
+func (s *Server) Addr() net.Addr {
+	return s.listen.Addr()
+}
+
+func (s *Server) GrpcServer() *grpc.Server {
+	return s.grpcServer
+}
+
+// 阻塞直到服务停止
+func (s *Server) Start() error {
+	if s.mux == MuxH2C {
+		err := s.httpServer.Serve(s.listen)
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	}
+
+	s.cMux = cmux.New(s.listen)
+	grpcListen := s.cMux.MatchWithWriters(cmux.HTTP2MatchHeaderFieldSendSettings("content-type", "application/grpc"))
+	httpListen := s.cMux.Match(cmux.HTTP1Fast())
+
+	go s.grpcServer.Serve(grpcListen)
+	go s.httpServer.Serve(httpListen)
+
+	err := s.cMux.Serve()
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// 启动服务，ctx结束后调用Shutdown，timeout为等待处理中的请求的最长时间，
+// 可以配合signal.NotifyContext在收到SIGTERM时优雅退出
+func (s *Server) Run(ctx context.Context, timeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Start()
+	}()
+
+	select {
+	case err := <-errCh:
+		s.Stop()
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.Shutdown(shutdownCtx)
+}
+
+// 停止接收新请求，等待处理中的请求完成，ctx结束后强制停止
+func (s *Server) Shutdown(ctx context.Context) error {
+	var err error
+	s.stopOnce.Do(func() {
+		err = s.httpServer.Shutdown(ctx)
+		if s.mux == MuxH2C {
+			//http.Server只等待未被劫持的连接，h2c连接中的请求需要单独等待，
+			//grpc.Server.GracefulStop不支持ServeHTTP，等待完成后直接Stop
+			if werr := s.waitInflight(ctx); err == nil {
+				err = werr
+			}
+			s.grpcServer.Stop()
+		} else if gerr := s.gracefulStop(ctx); err == nil {
+			err = gerr
+		}
+		s.cancel()
+		s.listen.Close()
+	})
+	return err
+}
+
+// GracefulStop会等待所有流结束，Watch这类长时间的流可能一直不结束，ctx结束后强制Stop
+func (s *Server) gracefulStop(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.grpcServer.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.grpcServer.Stop()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// 立即停止
+func (s *Server) Stop() {
+	s.stopOnce.Do(func() {
+		if s.httpServer != nil {
+			s.httpServer.Close()
+		}
+		s.grpcServer.Stop()
+		s.cancel()
+		s.listen.Close()
+	})
+}
+
+func (s *Server) newGrpcServer() *grpc.Server {
+	//多个ChainUnaryInterceptor按顺序执行，otelgrpc在最外层
+	opts := append(s.tracingServerOptions(),
+		grpc.ChainUnaryInterceptor(s.unary...),
+		grpc.ChainStreamInterceptor(s.stream...),
+	)
+	opts = append(opts, s.serverOpts...)
+
+	server := grpc.NewServer(opts...)
+	for _, fn := range s.services {
+		fn(server)
+	}
+	if s.reflection {
+		reflection.Register(server)
+	}
+	return server
+}
+
+func (s *Server) newHttpHandler(ctx context.Context) (http.Handler, error) {
+	mux := http.NewServeMux()
+
+	if len(s.gateways) > 0 {
+		gwOpts := append([]runtime.ServeMuxOption{
+			runtime.WithErrorHandler(errcode.GrpcGatewayError),
+			runtime.WithRoutingErrorHandler(errcode.GrpcGatewayRoutingError),
+		}, s.tracingGatewayOptions()...)
+		gwMux := runtime.NewServeMux(append(gwOpts, s.gatewayOpts...)...)
+
+		dialOpts := append([]grpc.DialOption{
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		}, s.tracingDialOptions()...)
+		dialOpts = append(dialOpts, s.dialOpts...)
+
+		endpoint := loopback(s.listen.Addr())
+		for _, fn := range s.gateways {
+			if err := fn(ctx, gwMux, endpoint, dialOpts); err != nil {
+				return nil, err
+			}
+		}
+
+		var gwHandler http.Handler = gwMux
+		for i := len(s.gatewayMiddlewares) - 1; i >= 0; i-- {
+			gwHandler = s.gatewayMiddlewares[i](gwHandler)
+		}
+		mux.Handle("/", s.tracingHandler(gwHandler))
+	}
+
+	for _, fn := range s.https {
+		fn(mux)
+	}
+
+	if s.mux != MuxH2C {
+		return mux, nil
+	}
+
+	s.h2Server = &http2.Server{}
+	return h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		isGrpc := r.ProtoMajor == 2 && strings.Contains(r.Header.Get("Content-Type"), "application/grpc")
+
+		if !s.begin() {
+			if isGrpc {
+				unavailable(w)
+			} else {
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			}
+			return
+		}
+		defer s.inflight.Done()
+
+		if isGrpc {
+			s.grpcServer.ServeHTTP(w, r)
+		} else {
+			mux.ServeHTTP(w, r)
+		}
+	}), s.h2Server), nil
+}
+
+// 开始处理h2c请求，Shutdown之后返回false
+func (s *Server) begin() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		return false
+	}
+	s.inflight.Add(1)
+	return true
+}
+
+// 拒绝新的h2c请求，等待处理中的请求完成或ctx结束
+func (s *Server) waitInflight(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 关闭过程中的grpc请求返回UNAVAILABLE，客户端可以重试到其他实例
+func unavailable(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(int(codes.Unavailable)))
+	w.Header().Set("Grpc-Message", "server is shutting down")
+	w.WriteHeader(http.StatusOK)
+}
+
+// gateway回环调用的地址，监听所有地址时使用127.0.0.1
+func loopback(addr net.Addr) string {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
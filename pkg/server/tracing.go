@@ -0,0 +1,75 @@
+package server
+
+import (
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/lackone/grpc-study/pkg/metatext"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"net/http"
+)
+
+// 链路追踪，grpc服务、gateway的http服务和gateway调用grpc的客户端都会创建span，
+// otel的拦截器和middleware始终在最外层，mp为nil时不记录otel指标
+func WithTracing(tp trace.TracerProvider, mp metric.MeterProvider) Option {
+	return func(s *Server) {
+		s.tracerProvider = tp
+		s.meterProvider = mp
+	}
+}
+
+func (s *Server) otelgrpcOptions() []otelgrpc.Option {
+	opts := []otelgrpc.Option{otelgrpc.WithTracerProvider(s.tracerProvider)}
+	if s.meterProvider != nil {
+		opts = append(opts, otelgrpc.WithMeterProvider(s.meterProvider))
+	}
+	return opts
+}
+
+func (s *Server) tracingServerOptions() []grpc.ServerOption {
+	if s.tracerProvider == nil {
+		return nil
+	}
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(otelgrpc.UnaryServerInterceptor(s.otelgrpcOptions()...)),
+		grpc.ChainStreamInterceptor(otelgrpc.StreamServerInterceptor(s.otelgrpcOptions()...)),
+	}
+}
+
+// 把trace context写入转发给grpc服务的metadata
+func (s *Server) tracingGatewayOptions() []runtime.ServeMuxOption {
+	if s.tracerProvider == nil {
+		return nil
+	}
+	return []runtime.ServeMuxOption{runtime.WithMetadata(metatext.GatewayMetadata)}
+}
+
+func (s *Server) tracingDialOptions() []grpc.DialOption {
+	if s.tracerProvider == nil {
+		return nil
+	}
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(otelgrpc.UnaryClientInterceptor(s.otelgrpcOptions()...)),
+		grpc.WithChainStreamInterceptor(otelgrpc.StreamClientInterceptor(s.otelgrpcOptions()...)),
+	}
+}
+
+// otelhttp读取请求中的traceparent、baggage，gateway的span作为grpc调用的父span
+func (s *Server) tracingHandler(h http.Handler) http.Handler {
+	if s.tracerProvider == nil {
+		return h
+	}
+
+	opts := []otelhttp.Option{
+		otelhttp.WithTracerProvider(s.tracerProvider),
+		otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		}),
+	}
+	if s.meterProvider != nil {
+		opts = append(opts, otelhttp.WithMeterProvider(s.meterProvider))
+	}
+	return otelhttp.NewHandler(h, "grpc-gateway", opts...)
+}
@@ -2,10 +2,13 @@ package service
 
 import (
 	"context"
+	"errors"
 	"github.com/lackone/grpc-study/pkg/db"
 	"github.com/lackone/grpc-study/pkg/errcode"
+	"github.com/lackone/grpc-study/pkg/logger"
 	"github.com/lackone/grpc-study/pkg/model"
 	pb "github.com/lackone/grpc-study/proto"
+	"go.uber.org/zap"
 )
 
 type ArticleService struct {
@@ -13,20 +16,26 @@ type ArticleService struct {
 }
 
 func (a *ArticleService) GetArticleList(ctx context.Context, req *pb.GetArticleRequest) (*pb.GetArticleResponse, error) {
+	//page和size的校验规则声明在article.proto中，由middleware.Validate拦截器校验
 	page := req.GetPage()
 	size := req.GetSize()
 
-	if page <= 0 || size <= 0 {
-		return nil, errcode.TogRPCError(errcode.ErrorGetArticleListRequestFail)
-	}
-
 	offset := (page - 1) * size
 
+	//使用请求的ctx，截止时间到了之后数据库查询会被取消
+	tx := db.DB.WithContext(ctx)
+
 	var articles []*pb.Article
-	db.DB.Model(&model.Article{}).Select("id, title").Order("id desc").Limit(int(size)).Offset(int(offset)).Find(&articles)
+	err := tx.Model(&model.Article{}).Select("id, title").Order("id desc").Limit(int(size)).Offset(int(offset)).Find(&articles).Error
+	if err != nil {
+		return nil, articleError(ctx, err)
+	}
 
 	var totalRows int32
-	db.DB.Model(&model.Article{}).Select("count(*) as cnt").Pluck("cnt", &totalRows)
+	err = tx.Model(&model.Article{}).Select("count(*) as cnt").Pluck("cnt", &totalRows).Error
+	if err != nil {
+		return nil, articleError(ctx, err)
+	}
 
 	return &pb.GetArticleResponse{
 		List: articles,
@@ -37,3 +46,12 @@ func (a *ArticleService) GetArticleList(ctx context.Context, req *pb.GetArticleR
 		},
 	}, nil
 }
+
+func articleError(ctx context.Context, err error) error {
+	logger.Ctx(ctx).Error("get article list failed", zap.Error(err))
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return errcode.DeadlineExceeded
+	}
+	return errcode.ErrorGetArticleListFail
+}
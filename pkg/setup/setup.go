@@ -0,0 +1,230 @@
+package setup
+
+import (
+	"context"
+	assetfs "github.com/elazarl/go-bindata-assetfs"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/lackone/grpc-study/pkg/auth"
+	"github.com/lackone/grpc-study/pkg/db"
+	"github.com/lackone/grpc-study/pkg/errcode"
+	"github.com/lackone/grpc-study/pkg/logger"
+	"github.com/lackone/grpc-study/pkg/metrics"
+	"github.com/lackone/grpc-study/pkg/middleware"
+	"github.com/lackone/grpc-study/pkg/requestid"
+	"github.com/lackone/grpc-study/pkg/server"
+	"github.com/lackone/grpc-study/pkg/service"
+	"github.com/lackone/grpc-study/pkg/swagger"
+	pb "github.com/lackone/grpc-study/proto"
+	"google.golang.org/grpc"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// 两个服务端入口共用的标准配置：trace和指标、拦截器链、文章服务、gateway和http接口，
+// opts追加在标准配置之后，入口只需要指定监听地址和分流方式，
+// 返回的shutdown在服务停止后调用
+func NewServer(opts ...server.Option) (*server.Server, func(context.Context) error, error) {
+	tracingOpts, shutdown, err := Tracing("grpc-server", "conf/tracer.json")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stdOpts, err := options()
+	if err != nil {
+		shutdown(context.Background())
+		return nil, nil, err
+	}
+
+	//opts在最后，可以覆盖标准配置中的监听地址和分流方式
+	s, err := server.NewServer(append(append(tracingOpts, stdOpts...), opts...)...)
+	if err != nil {
+		shutdown(context.Background())
+		return nil, nil, err
+	}
+	return s, shutdown, nil
+}
+
+// 日志、认证、限流、降载等配置，凭证和密钥文件可以通过环境变量指定
+func options() ([]server.Option, error) {
+	//数据库连接池指标
+	if err := metrics.RegisterDB(db.DB, "test"); err != nil {
+		return nil, err
+	}
+
+	//访问日志，Output可以配置成文件路径，按大小切割
+	accessLogger, err := logger.New(logger.Config{
+		Level:  "info",
+		Output: "stdout",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	//业务日志，拦截器和服务中通过logger.Ctx(ctx)记录，自动带上trace_id、request_id、method和调用方
+	appLogger, err := logger.New(logger.Config{
+		Level:  "info",
+		Output: "stdout",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	accessLogOpts := []middleware.AccessLogOption{
+		middleware.WithAccessLogger(accessLogger),
+		middleware.WithPayload("app_secret", "password"),
+	}
+
+	//app_key/app_secret凭证，也可以使用auth.NewDBStore(db.DB)
+	//客户端使用auth.HMACSigner签名，服务端校验签名、时间戳和nonce
+	//凭证文件不提交到仓库，格式参考conf/credentials.json.example
+	credentials, err := auth.NewFileStore(getenv("CREDENTIALS_FILE", "conf/credentials.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	//用户端应用使用JWT，方法需要的scope在proto中通过(proto.scopes)声明
+	//密钥文件不提交到仓库，格式参考conf/jwks.json.example
+	jwtVerifier, err := auth.NewJWTVerifier(auth.WithJWKSFile(getenv("JWKS_FILE", "conf/jwks.json")))
+	if err != nil {
+		return nil, err
+	}
+
+	authOpts := []middleware.AuthOption{
+		middleware.WithAuthSkip("/grpc.reflection.v1alpha.ServerReflection/"),
+		middleware.WithClockSkew(5 * time.Minute),
+		middleware.WithJWTVerifier(jwtVerifier),
+	}
+
+	//角色和允许调用的方法
+	policy, err := auth.LoadPolicy("conf/rbac.json")
+	if err != nil {
+		return nil, err
+	}
+
+	rbacOpts := []middleware.RBACOption{
+		middleware.WithDryRun(false),
+	}
+
+	//限流规则，默认按调用方限流，获取文章列表单独按客户端IP限流
+	rateLimitRules := []middleware.RateLimitRule{
+		{Method: "*", Key: middleware.LimitByAppKey, Rate: 100, Burst: 200},
+		{Method: "/proto.ArticleService/GetArticleList", Key: middleware.LimitByPeer, Rate: 20, Burst: 40},
+	}
+
+	//并发限制和自适应降载，保护数据库
+	loadShedOpts := []middleware.LoadShedOption{
+		middleware.WithMaxInFlight(100),
+		middleware.WithMethodMaxInFlight("/proto.ArticleService/GetArticleList", 50),
+		middleware.WithMaxQueueTime(100 * time.Millisecond),
+		middleware.WithTargetLatency(500 * time.Millisecond),
+	}
+
+	//请求的最大执行时间，剩余时间通过ctx传递给数据库和下游服务
+	deadlineOpts := []middleware.DeadlineOption{
+		middleware.WithDefaultDeadline(10 * time.Second),
+		middleware.WithMaxDeadline(30 * time.Second),
+		middleware.WithMethodDeadline("/proto.ArticleService/GetArticleList", 3*time.Second, 5*time.Second),
+	}
+
+	recoveryOpts := []middleware.RecoveryOption{
+		middleware.WithDebug(false),
+	}
+
+	return []server.Option{
+		//拦截器
+		server.WithUnaryInterceptors(
+			middleware.Metrics,
+			middleware.RequestId,
+			middleware.Logger(appLogger),
+			middleware.AccessLog(accessLogOpts...),
+			middleware.Error,
+			middleware.Recovery(recoveryOpts...),
+			middleware.HMACAuth(credentials, authOpts...),
+			middleware.RBAC(policy, rbacOpts...),
+			middleware.RateLimit(rateLimitRules...),
+			middleware.LoadShed(loadShedOpts...),
+			middleware.Deadline(deadlineOpts...),
+			middleware.Validate,
+		),
+		server.WithStreamInterceptors(
+			middleware.StreamMetrics,
+			middleware.StreamRequestId,
+			middleware.StreamLogger(appLogger),
+			middleware.StreamAccessLog(accessLogOpts...),
+			middleware.StreamError,
+			middleware.StreamRecovery(recoveryOpts...),
+			middleware.StreamHMACAuth(credentials, authOpts...),
+			middleware.StreamRBAC(policy, rbacOpts...),
+			middleware.StreamRateLimit(rateLimitRules...),
+			middleware.StreamLoadShed(loadShedOpts...),
+			middleware.StreamDeadline(deadlineOpts...),
+			middleware.StreamValidate,
+		),
+		server.WithService(func(srv *grpc.Server) {
+			pb.RegisterArticleServiceServer(srv, &service.ArticleService{})
+		}),
+		server.WithReflection(),
+		server.WithGateway(pb.RegisterArticleServiceHandlerFromEndpoint),
+		server.WithGatewayOptions(
+			//gateway不使用自己的身份，HTTP调用方通过Authorization传JWT，
+			//或通过X-App-Key、X-Timestamp、X-Nonce、X-Signature传自己的签名，按调用方认证和限流
+			runtime.WithIncomingHeaderMatcher(middleware.GatewayAuthHeaderMatcher),
+			runtime.WithErrorHandler(errcode.NewGrpcGatewayError(
+				errcode.WithOutgoingHeaderMatcher(middleware.GatewayHeaderMatcher),
+			)),
+			//限流信息转换成X-RateLimit-*响应头，X-Request-Id由requestid.Handler写入
+			runtime.WithOutgoingHeaderMatcher(middleware.GatewayHeaderMatcher),
+		),
+		//通过metadata把请求id转发给grpc服务
+		server.WithGatewayDialOptions(
+			grpc.WithChainUnaryInterceptor(middleware.UnaryClientRequestId()),
+			grpc.WithChainStreamInterceptor(middleware.StreamClientRequestId()),
+		),
+		server.WithGatewayMiddleware(
+			func(h http.Handler) http.Handler {
+				return metrics.InstrumentHandler("gateway", h)
+			},
+			//接收或生成X-Request-Id
+			requestid.Handler,
+		),
+		server.WithHttp(func(mux *http.ServeMux) {
+			//prometheus指标
+			mux.Handle("/metrics", metrics.Handler())
+
+			//配置swagger-ui
+			prefix := "/swagger-ui/"
+			fileServer := http.FileServer(&assetfs.AssetFS{
+				Asset:    swagger.Asset,
+				AssetDir: swagger.AssetDir,
+				Prefix:   "third_party/swagger-ui",
+			})
+			mux.Handle(prefix, http.StripPrefix(prefix, fileServer))
+
+			//读取swagger.json文件
+			mux.HandleFunc("/swagger/", func(w http.ResponseWriter, r *http.Request) {
+				if !strings.HasSuffix(r.URL.Path, "swagger.json") {
+					http.NotFound(w, r)
+					return
+				}
+				p := strings.TrimPrefix(r.URL.Path, "/swagger/")
+				p = path.Join("proto", p)
+				http.ServeFile(w, r, p)
+			})
+
+			mux.HandleFunc("/test", func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("test"))
+			})
+		}),
+	}, nil
+}
+
+// 读取环境变量，没有设置时使用默认值
+func getenv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,39 @@
+package setup
+
+import (
+	"context"
+	"errors"
+	"github.com/lackone/grpc-study/pkg/metrics"
+	"github.com/lackone/grpc-study/pkg/server"
+	"github.com/lackone/grpc-study/pkg/tracer"
+)
+
+// 按配置创建TracerProvider和MeterProvider，通过server.WithTracing使用，
+// 返回的shutdown在服务停止后调用，导出剩余的span和指标
+func Tracing(service, configPath string) ([]server.Option, func(context.Context) error, error) {
+	//导出器和采样率在配置文件中配置，collector无法连接时不采样
+	tracerConfig, err := tracer.LoadConfig(configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tp, err := tracer.NewTracerProvider(service, tracerConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	//otel指标和traces使用相同的resource，通过/metrics拉取，Output可以配置成文件路径
+	mp, err := tracer.InitMeterProvider(service, tracer.MeterConfig{
+		Registerer: metrics.Registry,
+	})
+	if err != nil {
+		tp.Shutdown(context.Background())
+		return nil, nil, err
+	}
+
+	shutdown := func(ctx context.Context) error {
+		return errors.Join(mp.Shutdown(ctx), tp.Shutdown(ctx))
+	}
+
+	return []server.Option{server.WithTracing(tp, mp)}, shutdown, nil
+}
@@ -0,0 +1,49 @@
+package tracer
+
+import (
+	promclient "github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/metric/global"
+	metricsdk "go.opentelemetry.io/otel/sdk/metric"
+	"time"
+)
+
+type MeterConfig struct {
+	//不为nil时，指标注册到该注册表，由/metrics拉取
+	Registerer promclient.Registerer
+	//定期以OTLP JSON格式导出的位置，stdout或文件路径，为空不导出
+	Output string
+	//定期导出的间隔，默认1分钟
+	Interval time.Duration
+}
+
+// 初始化meter provider并设置为全局，otelgrpc等instrumentation会使用全局的meter provider
+func InitMeterProvider(service string, cfg MeterConfig) (*metricsdk.MeterProvider, error) {
+	opts := []metricsdk.Option{
+		metricsdk.WithResource(newResource(service)),
+	}
+
+	if cfg.Registerer != nil {
+		exp, err := prometheus.New(prometheus.WithRegisterer(cfg.Registerer))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, metricsdk.WithReader(exp))
+	}
+
+	if cfg.Output != "" {
+		exp, err := newOTLPMetricExporter(cfg.Output)
+		if err != nil {
+			return nil, err
+		}
+		interval := cfg.Interval
+		if interval <= 0 {
+			interval = time.Minute
+		}
+		opts = append(opts, metricsdk.WithReader(metricsdk.NewPeriodicReader(exp, metricsdk.WithInterval(interval))))
+	}
+
+	mp := metricsdk.NewMeterProvider(opts...)
+	global.SetMeterProvider(mp)
+	return mp, nil
+}
@@ -0,0 +1,257 @@
+package tracer
+
+import (
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel/attribute"
+	metricsdk "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/aggregation"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// 以OTLP JSON格式导出指标，每次导出写一行MetricsData，和collector的file exporter格式一致
+type otlpMetricExporter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	closer   io.Closer
+	shutdown bool
+}
+
+// output为stdout或文件路径
+func newOTLPMetricExporter(output string) (*otlpMetricExporter, error) {
+	if output == "stdout" {
+		return &otlpMetricExporter{w: os.Stdout}, nil
+	}
+
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &otlpMetricExporter{w: f, closer: f}, nil
+}
+
+func (e *otlpMetricExporter) Temporality(k metricsdk.InstrumentKind) metricdata.Temporality {
+	return metricsdk.DefaultTemporalitySelector(k)
+}
+
+func (e *otlpMetricExporter) Aggregation(k metricsdk.InstrumentKind) aggregation.Aggregation {
+	return metricsdk.DefaultAggregationSelector(k)
+}
+
+func (e *otlpMetricExporter) Export(ctx context.Context, rm metricdata.ResourceMetrics) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	data, err := protojson.Marshal(&metricpb.MetricsData{
+		ResourceMetrics: []*metricpb.ResourceMetrics{toResourceMetrics(rm)},
+	})
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.shutdown {
+		return errors.New("exporter is shutdown")
+	}
+	_, err = e.w.Write(append(data, '\n'))
+	return err
+}
+
+func (e *otlpMetricExporter) ForceFlush(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (e *otlpMetricExporter) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.shutdown {
+		return nil
+	}
+	e.shutdown = true
+	if e.closer != nil {
+		return e.closer.Close()
+	}
+	return nil
+}
+
+func toResourceMetrics(rm metricdata.ResourceMetrics) *metricpb.ResourceMetrics {
+	out := &metricpb.ResourceMetrics{}
+	if rm.Resource != nil {
+		out.Resource = &resourcepb.Resource{Attributes: toKeyValues(rm.Resource.Iter())}
+		out.SchemaUrl = rm.Resource.SchemaURL()
+	}
+
+	for _, sm := range rm.ScopeMetrics {
+		scope := &metricpb.ScopeMetrics{
+			Scope: &commonpb.InstrumentationScope{
+				Name:    sm.Scope.Name,
+				Version: sm.Scope.Version,
+			},
+			SchemaUrl: sm.Scope.SchemaURL,
+		}
+		for _, m := range sm.Metrics {
+			if metric := toMetric(m); metric != nil {
+				scope.Metrics = append(scope.Metrics, metric)
+			}
+		}
+		out.ScopeMetrics = append(out.ScopeMetrics, scope)
+	}
+	return out
+}
+
+// 不支持的聚合类型返回nil
+func toMetric(m metricdata.Metrics) *metricpb.Metric {
+	out := &metricpb.Metric{
+		Name:        m.Name,
+		Description: m.Description,
+		Unit:        string(m.Unit),
+	}
+
+	switch a := m.Data.(type) {
+	case metricdata.Gauge[int64]:
+		out.Data = &metricpb.Metric_Gauge{Gauge: &metricpb.Gauge{DataPoints: toNumberDataPoints(a.DataPoints)}}
+	case metricdata.Gauge[float64]:
+		out.Data = &metricpb.Metric_Gauge{Gauge: &metricpb.Gauge{DataPoints: toNumberDataPoints(a.DataPoints)}}
+	case metricdata.Sum[int64]:
+		out.Data = &metricpb.Metric_Sum{Sum: &metricpb.Sum{
+			AggregationTemporality: toTemporality(a.Temporality),
+			IsMonotonic:            a.IsMonotonic,
+			DataPoints:             toNumberDataPoints(a.DataPoints),
+		}}
+	case metricdata.Sum[float64]:
+		out.Data = &metricpb.Metric_Sum{Sum: &metricpb.Sum{
+			AggregationTemporality: toTemporality(a.Temporality),
+			IsMonotonic:            a.IsMonotonic,
+			DataPoints:             toNumberDataPoints(a.DataPoints),
+		}}
+	case metricdata.Histogram:
+		out.Data = &metricpb.Metric_Histogram{Histogram: &metricpb.Histogram{
+			AggregationTemporality: toTemporality(a.Temporality),
+			DataPoints:             toHistogramDataPoints(a.DataPoints),
+		}}
+	default:
+		return nil
+	}
+	return out
+}
+
+func toNumberDataPoints[N int64 | float64](dps []metricdata.DataPoint[N]) []*metricpb.NumberDataPoint {
+	out := make([]*metricpb.NumberDataPoint, 0, len(dps))
+	for _, dp := range dps {
+		p := &metricpb.NumberDataPoint{
+			Attributes:        toKeyValues(dp.Attributes.Iter()),
+			StartTimeUnixNano: unixNano(dp.StartTime),
+			TimeUnixNano:      unixNano(dp.Time),
+		}
+		switch v := any(dp.Value).(type) {
+		case int64:
+			p.Value = &metricpb.NumberDataPoint_AsInt{AsInt: v}
+		case float64:
+			p.Value = &metricpb.NumberDataPoint_AsDouble{AsDouble: v}
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+func toHistogramDataPoints(dps []metricdata.HistogramDataPoint) []*metricpb.HistogramDataPoint {
+	out := make([]*metricpb.HistogramDataPoint, 0, len(dps))
+	for _, dp := range dps {
+		sum := dp.Sum
+		p := &metricpb.HistogramDataPoint{
+			Attributes:        toKeyValues(dp.Attributes.Iter()),
+			StartTimeUnixNano: unixNano(dp.StartTime),
+			TimeUnixNano:      unixNano(dp.Time),
+			Count:             dp.Count,
+			Sum:               &sum,
+			BucketCounts:      dp.BucketCounts,
+			ExplicitBounds:    dp.Bounds,
+		}
+		if v, ok := dp.Min.Value(); ok {
+			p.Min = &v
+		}
+		if v, ok := dp.Max.Value(); ok {
+			p.Max = &v
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+func toTemporality(t metricdata.Temporality) metricpb.AggregationTemporality {
+	switch t {
+	case metricdata.CumulativeTemporality:
+		return metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	case metricdata.DeltaTemporality:
+		return metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+	}
+	return metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_UNSPECIFIED
+}
+
+func toKeyValues(iter attribute.Iterator) []*commonpb.KeyValue {
+	out := make([]*commonpb.KeyValue, 0, iter.Len())
+	for iter.Next() {
+		kv := iter.Attribute()
+		out = append(out, &commonpb.KeyValue{Key: string(kv.Key), Value: toAnyValue(kv.Value)})
+	}
+	return out
+}
+
+func toAnyValue(v attribute.Value) *commonpb.AnyValue {
+	switch v.Type() {
+	case attribute.BOOL:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: v.AsBool()}}
+	case attribute.INT64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: v.AsInt64()}}
+	case attribute.FLOAT64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: v.AsFloat64()}}
+	case attribute.BOOLSLICE:
+		var values []*commonpb.AnyValue
+		for _, b := range v.AsBoolSlice() {
+			values = append(values, &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: b}})
+		}
+		return arrayValue(values)
+	case attribute.INT64SLICE:
+		var values []*commonpb.AnyValue
+		for _, i := range v.AsInt64Slice() {
+			values = append(values, &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: i}})
+		}
+		return arrayValue(values)
+	case attribute.FLOAT64SLICE:
+		var values []*commonpb.AnyValue
+		for _, f := range v.AsFloat64Slice() {
+			values = append(values, &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: f}})
+		}
+		return arrayValue(values)
+	case attribute.STRINGSLICE:
+		var values []*commonpb.AnyValue
+		for _, s := range v.AsStringSlice() {
+			values = append(values, &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: s}})
+		}
+		return arrayValue(values)
+	}
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v.Emit()}}
+}
+
+func arrayValue(values []*commonpb.AnyValue) *commonpb.AnyValue {
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: values}}}
+}
+
+func unixNano(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
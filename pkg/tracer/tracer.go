@@ -1,28 +1,218 @@
 package tracer
 
 import (
+	"context"
+	"encoding/json"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/jaeger"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	tracesdk "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
 )
 
+// 导出器类型
+const (
+	ExporterJaeger   = "jaeger"
+	ExporterOTLPGrpc = "otlp-grpc"
+	ExporterOTLPHttp = "otlp-http"
+	ExporterStdout   = "stdout"
+	ExporterFile     = "file"
+	ExporterNone     = "none"
+)
+
+// 探测collector是否可以连接的超时时间
+const connectTimeout = 3 * time.Second
+
+type Config struct {
+	//jaeger, otlp-grpc, otlp-http, stdout, file, none
+	Exporter string `json:"exporter"`
+	//jaeger agent或otlp collector的地址，如 127.0.0.1:4317
+	Endpoint string `json:"endpoint"`
+	//otlp不使用TLS
+	Insecure bool `json:"insecure"`
+	//file导出器的文件路径
+	Output string `json:"output"`
+	//没有父span时的采样率，有父span时跟随父span
+	SampleRatio float64 `json:"sample_ratio"`
+	//按方法设置没有父span时的采样率，有父span时同样跟随父span，可以是完整方法名或服务名前缀，如 /grpc.health.v1.Health/
+	MethodSampleRatios map[string]float64 `json:"method_sample_ratios"`
+}
+
+// 读取json配置，sample_ratio默认为1
+func LoadConfig(path string) (Config, error) {
+	cfg := Config{Exporter: ExporterJaeger, SampleRatio: 1}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// 使用jaeger agent导出，全部采样
 func InitTracerProvider(host, port, service string) (*tracesdk.TracerProvider, error) {
-	exp, err := jaeger.New(jaeger.WithAgentEndpoint(jaeger.WithAgentHost(host), jaeger.WithAgentPort(port)))
+	return NewTracerProvider(service, Config{
+		Exporter:    ExporterJaeger,
+		Endpoint:    net.JoinHostPort(host, port),
+		SampleRatio: 1,
+	})
+}
+
+// 按配置创建tracer provider并设置为全局，collector无法连接时退化为不采样的provider，不影响服务启动
+func NewTracerProvider(service string, cfg Config) (*tracesdk.TracerProvider, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	exp, err := newExporter(cfg)
 	if err != nil {
 		return nil, err
 	}
+
+	if exp == nil {
+		//没有导出器时不采样，但仍然会传递上游的trace context
+		tp := tracesdk.NewTracerProvider(
+			tracesdk.WithSampler(tracesdk.NeverSample()),
+			tracesdk.WithResource(newResource(service)),
+		)
+		otel.SetTracerProvider(tp)
+		return tp, nil
+	}
+
 	tp := tracesdk.NewTracerProvider(
-		tracesdk.WithSampler(tracesdk.AlwaysSample()),
+		tracesdk.WithSampler(newSampler(cfg)),
 		tracesdk.WithBatcher(exp),
-		tracesdk.WithResource(resource.NewWithAttributes(
-			semconv.SchemaURL,
-			semconv.ServiceName(service),
-		)),
+		tracesdk.WithResource(newResource(service)),
 	)
 	otel.SetTracerProvider(tp)
-	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
 	return tp, nil
 }
+
+// 返回nil表示不导出
+func newExporter(cfg Config) (tracesdk.SpanExporter, error) {
+	switch cfg.Exporter {
+	case ExporterNone:
+		return nil, nil
+	case ExporterStdout:
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case ExporterFile:
+		f, err := os.OpenFile(cfg.Output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &fileSpanExporter{SpanExporter: exp, file: f}, nil
+	case ExporterJaeger, "":
+		host, port, err := net.SplitHostPort(cfg.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		return jaeger.New(jaeger.WithAgentEndpoint(jaeger.WithAgentHost(host), jaeger.WithAgentPort(port)))
+	}
+
+	//otlp通过tcp连接，启动时探测一次
+	if !reachable(cfg.Endpoint) {
+		log.Printf("tracer: collector %s unreachable, tracing disabled\n", cfg.Endpoint)
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+
+	switch cfg.Exporter {
+	case ExporterOTLPGrpc:
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	case ExporterOTLPHttp:
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(ctx, opts...)
+	}
+
+	log.Printf("tracer: unknown exporter %q, tracing disabled\n", cfg.Exporter)
+	return nil, nil
+}
+
+func reachable(endpoint string) bool {
+	conn, err := net.DialTimeout("tcp", endpoint, connectTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// 关闭时同时关闭文件
+type fileSpanExporter struct {
+	tracesdk.SpanExporter
+	file *os.File
+}
+
+func (e *fileSpanExporter) Shutdown(ctx context.Context) error {
+	err := e.SpanExporter.Shutdown(ctx)
+	if cerr := e.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// 按方法的采样率只用于根span，有父span时跟随父span，不会把已有的trace拆开
+func newSampler(cfg Config) tracesdk.Sampler {
+	s := &methodSampler{
+		fallback: tracesdk.TraceIDRatioBased(cfg.SampleRatio),
+		methods:  map[string]tracesdk.Sampler{},
+	}
+	for method, ratio := range cfg.MethodSampleRatios {
+		s.methods[strings.TrimPrefix(method, "/")] = tracesdk.TraceIDRatioBased(ratio)
+	}
+	return tracesdk.ParentBased(s)
+}
+
+// otelgrpc的span名称是去掉开头/的方法名，如 proto.ArticleService/GetArticleList
+type methodSampler struct {
+	fallback tracesdk.Sampler
+	methods  map[string]tracesdk.Sampler
+}
+
+func (s *methodSampler) ShouldSample(p tracesdk.SamplingParameters) tracesdk.SamplingResult {
+	if sampler, ok := s.methods[p.Name]; ok {
+		return sampler.ShouldSample(p)
+	}
+	if i := strings.LastIndex(p.Name, "/"); i > 0 {
+		if sampler, ok := s.methods[p.Name[:i+1]]; ok {
+			return sampler.ShouldSample(p)
+		}
+	}
+	return s.fallback.ShouldSample(p)
+}
+
+func (s *methodSampler) Description() string {
+	return "MethodSampler{" + s.fallback.Description() + "}"
+}
+
+// traces和metrics使用相同的resource
+func newResource(service string) *resource.Resource {
+	return resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(service),
+	)
+}
@@ -0,0 +1,229 @@
+// tracertest使用pkg/server启动一个grpc服务和grpc-gateway，通过server.WithTracing注入内存span导出器，
+// 和服务端入口的链路追踪配置相同，用于在测试中检查链路追踪，不需要jaeger。
+//
+//	s, err := tracertest.NewServer(
+//		tracertest.WithService(func(server *grpc.Server) {
+//			pb.RegisterArticleServiceServer(server, &fakeArticleService{})
+//		}),
+//		tracertest.WithGateway(pb.RegisterArticleServiceHandlerFromEndpoint),
+//	)
+//	defer s.Close()
+//
+//	pb.NewArticleServiceClient(s.Conn).GetArticleList(ctx, req)
+//	client, _ := s.FindSpanByKind("proto.ArticleService/GetArticleList", trace.SpanKindClient)
+//	server, _ := s.FindSpanByKind("proto.ArticleService/GetArticleList", trace.SpanKindServer)
+//	tracertest.IsChildOf(server, client)
+package tracertest
+
+import (
+	"context"
+	"github.com/lackone/grpc-study/pkg/server"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"net/http"
+)
+
+type Option func(*Server)
+
+// 注册grpc服务，不要使用依赖数据库的service.ArticleService
+func WithService(fn func(*grpc.Server)) Option {
+	return func(s *Server) {
+		s.opts = append(s.opts, server.WithService(fn))
+	}
+}
+
+// 注册grpc-gateway的handler
+func WithGateway(fn server.GatewayRegisterFunc) Option {
+	return func(s *Server) {
+		s.opts = append(s.opts, server.WithGateway(fn))
+	}
+}
+
+// 追加的服务端拦截器，在otelgrpc之后执行
+func WithUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) Option {
+	return func(s *Server) {
+		s.opts = append(s.opts, server.WithUnaryInterceptors(interceptors...))
+	}
+}
+
+func WithStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) Option {
+	return func(s *Server) {
+		s.opts = append(s.opts, server.WithStreamInterceptors(interceptors...))
+	}
+}
+
+// grpc和http的分流方式，默认server.MuxCmux
+func WithMux(mux server.Mux) Option {
+	return func(s *Server) {
+		s.opts = append(s.opts, server.WithMux(mux))
+	}
+}
+
+type Server struct {
+	//grpc服务地址，和gateway共用一个端口
+	GrpcAddr string
+	//grpc-gateway的地址，如 http://127.0.0.1:12345
+	URL string
+	//连接grpc服务的客户端，带有otelgrpc拦截器
+	Conn *grpc.ClientConn
+	//带有otelhttp的http客户端，请求gateway时会创建客户端span
+	HTTPClient *http.Client
+
+	Exporter       *tracetest.InMemoryExporter
+	TracerProvider *sdktrace.TracerProvider
+
+	opts   []server.Option
+	server *server.Server
+	//Close时恢复之前的全局propagator
+	propagator propagation.TextMapPropagator
+}
+
+func NewServer(opts ...Option) (*Server, error) {
+	s := &Server{
+		Exporter: tracetest.NewInMemoryExporter(),
+	}
+	for _, fn := range opts {
+		fn(s)
+	}
+
+	//同步导出，span结束后立即可以查询
+	s.TracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+		sdktrace.WithSyncer(s.Exporter),
+	)
+	propagator := propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+	//otelgrpc、metatext使用全局的propagator
+	s.propagator = otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagator)
+
+	srv, err := server.NewServer(append([]server.Option{
+		server.WithEndpoint("127.0.0.1:0"),
+		server.WithTracing(s.TracerProvider, nil),
+	}, s.opts...)...)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.server = srv
+	go srv.Start()
+
+	s.GrpcAddr = srv.Addr().String()
+	s.URL = "http://" + s.GrpcAddr
+
+	otelOpts := []otelgrpc.Option{
+		otelgrpc.WithTracerProvider(s.TracerProvider),
+		otelgrpc.WithPropagators(propagator),
+	}
+	s.Conn, err = grpc.Dial(s.GrpcAddr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor(otelOpts...)),
+		grpc.WithStreamInterceptor(otelgrpc.StreamClientInterceptor(otelOpts...)),
+	)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	s.HTTPClient = &http.Client{
+		Transport: otelhttp.NewTransport(http.DefaultTransport,
+			otelhttp.WithTracerProvider(s.TracerProvider),
+			otelhttp.WithPropagators(propagator),
+		),
+	}
+
+	return s, nil
+}
+
+func (s *Server) Close() {
+	if s.Conn != nil {
+		s.Conn.Close()
+	}
+	if s.server != nil {
+		s.server.Stop()
+	}
+	s.TracerProvider.Shutdown(context.Background())
+	otel.SetTextMapPropagator(s.propagator)
+}
+
+// 清空已记录的span
+func (s *Server) Reset() {
+	s.Exporter.Reset()
+}
+
+// 已经结束的span
+func (s *Server) Spans() tracetest.SpanStubs {
+	return s.Exporter.GetSpans()
+}
+
+// 按名称和属性查找span，名称为空时只匹配属性
+func (s *Server) FindSpans(name string, attrs ...attribute.KeyValue) tracetest.SpanStubs {
+	var spans tracetest.SpanStubs
+	for _, span := range s.Spans() {
+		if name != "" && span.Name != name {
+			continue
+		}
+		if hasAttributes(span, attrs) {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func (s *Server) FindSpan(name string, attrs ...attribute.KeyValue) (tracetest.SpanStub, bool) {
+	spans := s.FindSpans(name, attrs...)
+	if len(spans) == 0 {
+		return tracetest.SpanStub{}, false
+	}
+	return spans[0], true
+}
+
+// 按名称和span类型查找，如grpc调用的客户端span和服务端span名称相同
+func (s *Server) FindSpanByKind(name string, kind trace.SpanKind) (tracetest.SpanStub, bool) {
+	for _, span := range s.FindSpans(name) {
+		if span.SpanKind == kind {
+			return span, true
+		}
+	}
+	return tracetest.SpanStub{}, false
+}
+
+// 查找父span
+func (s *Server) Parent(span tracetest.SpanStub) (tracetest.SpanStub, bool) {
+	for _, p := range s.Spans() {
+		if IsChildOf(span, p) {
+			return p, true
+		}
+	}
+	return tracetest.SpanStub{}, false
+}
+
+// child的父span是否是parent，且在同一个trace中
+func IsChildOf(child, parent tracetest.SpanStub) bool {
+	return child.Parent.IsValid() &&
+		child.Parent.TraceID() == parent.SpanContext.TraceID() &&
+		child.Parent.SpanID() == parent.SpanContext.SpanID()
+}
+
+func hasAttributes(span tracetest.SpanStub, attrs []attribute.KeyValue) bool {
+	for _, want := range attrs {
+		found := false
+		for _, got := range span.Attributes {
+			if got.Key == want.Key && got.Value.Type() == want.Value.Type() && got.Value.Emit() == want.Value.Emit() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+package tracertest_test
+
+import (
+	"context"
+	"github.com/lackone/grpc-study/pkg/server"
+	"github.com/lackone/grpc-study/pkg/tracertest"
+	pb "github.com/lackone/grpc-study/proto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type fakeArticleService struct {
+	pb.UnimplementedArticleServiceServer
+}
+
+func (fakeArticleService) GetArticleList(ctx context.Context, req *pb.GetArticleRequest) (*pb.GetArticleResponse, error) {
+	return &pb.GetArticleResponse{List: []*pb.Article{{Id: 1, Title: "test"}}}, nil
+}
+
+func newServer(t *testing.T, opts ...tracertest.Option) *tracertest.Server {
+	t.Helper()
+
+	s, err := tracertest.NewServer(append([]tracertest.Option{
+		tracertest.WithService(func(server *grpc.Server) {
+			pb.RegisterArticleServiceServer(server, fakeArticleService{})
+			healthpb.RegisterHealthServer(server, health.NewServer())
+		}),
+		tracertest.WithGateway(pb.RegisterArticleServiceHandlerFromEndpoint),
+	}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// 客户端span和服务端span在同一个trace中，服务端span的父span是客户端span
+func assertLinked(t *testing.T, s *tracertest.Server, name string) (client, server tracetest.SpanStub) {
+	t.Helper()
+
+	client, ok := s.FindSpanByKind(name, trace.SpanKindClient)
+	if !ok {
+		t.Fatalf("client span %s not found, spans: %d", name, len(s.Spans()))
+	}
+	server, ok = s.FindSpanByKind(name, trace.SpanKindServer)
+	if !ok {
+		t.Fatalf("server span %s not found", name)
+	}
+
+	if server.SpanContext.TraceID() != client.SpanContext.TraceID() {
+		t.Fatalf("trace id: server %s, client %s", server.SpanContext.TraceID(), client.SpanContext.TraceID())
+	}
+	if !tracertest.IsChildOf(server, client) {
+		t.Fatalf("server span parent %s, want client span %s", server.Parent.SpanID(), client.SpanContext.SpanID())
+	}
+	return client, server
+}
+
+func TestUnarySpansLinked(t *testing.T) {
+	s := newServer(t)
+
+	_, err := pb.NewArticleServiceClient(s.Conn).GetArticleList(context.Background(), &pb.GetArticleRequest{Page: 1, Size: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertLinked(t, s, "proto.ArticleService/GetArticleList")
+}
+
+func TestStreamSpansLinked(t *testing.T) {
+	s := newServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := healthpb.NewHealthClient(s.Conn).Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	//Watch不会主动结束，取消后两端的span才会结束
+	cancel()
+	stream.Recv()
+
+	name := "grpc.health.v1.Health/Watch"
+	for i := 0; i < 100; i++ {
+		if _, ok := s.FindSpanByKind(name, trace.SpanKindServer); ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assertLinked(t, s, name)
+}
+
+// 两种分流方式下gateway都要把trace context传给grpc服务
+func TestGatewaySpansLinked(t *testing.T) {
+	for name, mux := range map[string]server.Mux{"cmux": server.MuxCmux, "h2c": server.MuxH2C} {
+		t.Run(name, func(t *testing.T) {
+			s := newServer(t, tracertest.WithMux(mux))
+
+			resp, err := s.HTTPClient.Get(s.URL + "/v1/get_article_list?page=1&size=10")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status %d", resp.StatusCode)
+			}
+
+			client, _ := assertLinked(t, s, "proto.ArticleService/GetArticleList")
+
+			//gateway的grpc调用的父span是otelhttp的服务端span
+			gateway, ok := s.Parent(client)
+			if !ok || gateway.Name != "GET /v1/get_article_list" {
+				t.Fatalf("gateway span not found, parent: %q", gateway.Name)
+			}
+		})
+	}
+}
+
+func TestClosePropagator(t *testing.T) {
+	prev := otel.GetTextMapPropagator()
+	defer otel.SetTextMapPropagator(prev)
+
+	want := propagation.Baggage{}
+	otel.SetTextMapPropagator(want)
+
+	s, err := tracertest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	if got := otel.GetTextMapPropagator(); got != want {
+		t.Fatalf("propagator not restored: %T", got)
+	}
+}
@@ -0,0 +1,146 @@
+package validate
+
+import (
+	"fmt"
+	"github.com/lackone/grpc-study/pkg/errcode"
+	pb "github.com/lackone/grpc-study/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"regexp"
+	"sync"
+	"unicode/utf8"
+)
+
+var patterns sync.Map
+
+// 按字段上的(proto.rules)校验消息，失败时返回带BadRequest详情的errcode.InvalidParams
+func Validate(m proto.Message) error {
+	violations := validateMessage(m.ProtoReflect(), "")
+	if len(violations) == 0 {
+		return nil
+	}
+	return errcode.InvalidParams.WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+}
+
+func validateMessage(m protoreflect.Message, prefix string) []*errdetails.BadRequest_FieldViolation {
+	var violations []*errdetails.BadRequest_FieldViolation
+
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		name := prefix + string(fd.Name())
+
+		if rules := fieldRules(fd); rules != nil {
+			for _, desc := range validateField(m, fd, rules) {
+				violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: name, Description: desc})
+			}
+		}
+
+		//嵌套消息
+		if fd.Kind() != protoreflect.MessageKind || fd.IsMap() || !m.Has(fd) {
+			continue
+		}
+		if fd.IsList() {
+			list := m.Get(fd).List()
+			for j := 0; j < list.Len(); j++ {
+				violations = append(violations, validateMessage(list.Get(j).Message(), fmt.Sprintf("%s[%d].", name, j))...)
+			}
+			continue
+		}
+		violations = append(violations, validateMessage(m.Get(fd).Message(), name+".")...)
+	}
+
+	return violations
+}
+
+func fieldRules(fd protoreflect.FieldDescriptor) *pb.FieldRules {
+	opts := fd.Options()
+	if opts == nil || !proto.HasExtension(opts, pb.E_Rules) {
+		return nil
+	}
+	rules, _ := proto.GetExtension(opts, pb.E_Rules).(*pb.FieldRules)
+	return rules
+}
+
+func validateField(m protoreflect.Message, fd protoreflect.FieldDescriptor, rules *pb.FieldRules) []string {
+	var descs []string
+
+	if rules.Required && !m.Has(fd) {
+		return append(descs, "不能为空")
+	}
+
+	v := m.Get(fd)
+
+	//repeated和map校验元素个数
+	if fd.IsList() || fd.IsMap() {
+		n := 0
+		if fd.IsList() {
+			n = v.List().Len()
+		} else {
+			n = v.Map().Len()
+		}
+		return append(descs, checkLen(n, rules)...)
+	}
+
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		s := v.String()
+		descs = append(descs, checkLen(utf8.RuneCountInString(s), rules)...)
+		if rules.Pattern != "" {
+			re, err := compile(rules.Pattern)
+			if err != nil {
+				descs = append(descs, fmt.Sprintf("无效的校验规则 %q", rules.Pattern))
+			} else if !re.MatchString(s) {
+				descs = append(descs, fmt.Sprintf("必须匹配 %s", rules.Pattern))
+			}
+		}
+	case protoreflect.BytesKind:
+		descs = append(descs, checkLen(len(v.Bytes()), rules)...)
+	case protoreflect.Int32Kind, protoreflect.Int64Kind, protoreflect.Sint32Kind, protoreflect.Sint64Kind,
+		protoreflect.Sfixed32Kind, protoreflect.Sfixed64Kind:
+		descs = append(descs, checkRange(float64(v.Int()), rules)...)
+	case protoreflect.Uint32Kind, protoreflect.Uint64Kind, protoreflect.Fixed32Kind, protoreflect.Fixed64Kind:
+		descs = append(descs, checkRange(float64(v.Uint()), rules)...)
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		descs = append(descs, checkRange(v.Float(), rules)...)
+	case protoreflect.EnumKind:
+		descs = append(descs, checkRange(float64(v.Enum()), rules)...)
+	}
+
+	return descs
+}
+
+func checkRange(n float64, rules *pb.FieldRules) []string {
+	var descs []string
+	if rules.Gte != nil && n < rules.GetGte() {
+		descs = append(descs, fmt.Sprintf("必须大于等于 %v", rules.GetGte()))
+	}
+	if rules.Lte != nil && n > rules.GetLte() {
+		descs = append(descs, fmt.Sprintf("必须小于等于 %v", rules.GetLte()))
+	}
+	return descs
+}
+
+func checkLen(n int, rules *pb.FieldRules) []string {
+	var descs []string
+	if rules.MinLen != nil && n < int(rules.GetMinLen()) {
+		descs = append(descs, fmt.Sprintf("长度不能小于 %d", rules.GetMinLen()))
+	}
+	if rules.MaxLen != nil && n > int(rules.GetMaxLen()) {
+		descs = append(descs, fmt.Sprintf("长度不能大于 %d", rules.GetMaxLen()))
+	}
+	return descs
+}
+
+func compile(pattern string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(pattern, re)
+	return re, nil
+}
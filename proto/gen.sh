@@ -3,5 +3,5 @@
 protoc --go_out=. --go_opt=paths=source_relative \
   --go-grpc_out=. --go-grpc_opt=paths=source_relative \
   --grpc-gateway_out=. --grpc-gateway_opt=logtostderr=true --grpc-gateway_opt=paths=source_relative \
-  --openapiv2_out=. --openapiv2_opt=logtostderr=true \
+  --openapiv2_out=. --openapiv2_opt=logtostderr=true --openapiv2_opt=disable_default_errors=true \
    *.proto
\ No newline at end of file
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"github.com/lackone/grpc-study/pkg/server"
+	"github.com/lackone/grpc-study/pkg/setup"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	//收到SIGINT、SIGTERM后停止接收新请求，最多等待10秒处理中的请求完成
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	//cmux按连接区分grpc和http，其他配置和server/h2c相同
+	s, shutdown, err := setup.NewServer(
+		server.WithEndpoint("127.0.0.1:8080"),
+		server.WithMux(server.MuxCmux),
+	)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if err := s.Run(ctx, 10*time.Second); err != nil {
+		log.Println(err)
+	}
+
+	//服务停止后导出剩余的span和指标
+	if err := shutdown(context.Background()); err != nil {
+		log.Fatalln(err)
+	}
+}
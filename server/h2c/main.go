@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/lackone/grpc-study/pkg/db"
+	"github.com/lackone/grpc-study/pkg/model"
+	"github.com/lackone/grpc-study/pkg/server"
+	"github.com/lackone/grpc-study/pkg/setup"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var port string
+
+func init() {
+	flag.StringVar(&port, "port", "8080", "启动端口号")
+	flag.Parse()
+}
+
+func main() {
+	initTestData()
+
+	if err := RunServer(port); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func RunServer(port string) error {
+	//收到SIGINT、SIGTERM后停止接收新请求，最多等待10秒处理中的请求完成
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	//h2c按请求区分grpc和http，其他配置和server/cmux相同
+	s, shutdown, err := setup.NewServer(
+		server.WithEndpoint(":"+port),
+		server.WithMux(server.MuxH2C),
+	)
+	if err != nil {
+		return err
+	}
+	defer shutdown(context.Background())
+
+	return s.Run(ctx, 10*time.Second)
+}
+
+func initTestData() {
+	db.DB.AutoMigrate(&model.Article{})
+}